@@ -1,9 +1,8 @@
 package app
 
 import (
-	"fmt"
-	"os"
-	"regexp"
+	"bufio"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -25,34 +24,117 @@ type KeyAction struct {
 	ToggleHelp    bool
 	ScrollUp      bool
 	ScrollDown    bool
+
+	SuppressionSelected int
+	AddSuppression      string
+	RemoveSuppression   string
+	Search              string
+
+	OpenCSV        string
+	ColumnSelected int
+	MapColumn      string
+	SkipColumn     bool
+	ToggleHeader   bool
+	NextDelimiter  bool
+	CommitImport   bool
+	CancelImport   bool
+
+	ShowHistory    bool
+	CloseHistory   bool
+	ImportSelected int
+	ConfirmUndo    bool
+	CancelUndo     bool
+	UndoImport     bool
+
+	OpenDir       string
+	NextExtension bool
+	ClearFilter   bool
+
+	OpenSQLite  string
+	RunQuery    string
+	CancelQuery bool
+
+	CancelProgress bool
 }
 
-func (a *App) HandleKeyPress(key string, currentScreen int, confirmStart bool, mailStarted bool, inputFocused bool, selectedFile int, files []string, delayValue string) KeyAction {
-	action := KeyAction{SetScreen: -1, FileSelected: -1}
+func (a *App) HandleKeyPress(key string, currentScreen int, confirmStart bool, mailStarted bool, inputFocused bool, selectedFile int, files []ImportEntry, inputValue string) KeyAction {
+	action := KeyAction{SetScreen: -1, FileSelected: -1, SuppressionSelected: -1, ColumnSelected: -1, ImportSelected: -1}
+
+	// While a text input has focus, every other key is typed into it
+	if inputFocused && key != "ctrl+c" && key != "enter" && key != "esc" {
+		return action
+	}
+
+	// Import running in the background; other keys keep their global meaning
+	running := currentScreen == 3 && a.viewData.ImportProgress != nil
+	if running && (key == "esc" || key == "n") {
+		action.CancelProgress = true
+		return action
+	}
+
+	// Preview step of an import
+	if currentScreen == 3 && !running && a.viewData.ImportPlan != nil && !inputFocused {
+		switch key {
+		case "y":
+			action.CommitImport = true
+			return action
+		case "n", "esc":
+			action.CancelImport = true
+			return action
+		case "up":
+			action.ScrollUp = true
+			return action
+		case "down":
+			action.ScrollDown = true
+			return action
+		}
+	}
+
+	// Import history
+	if currentScreen == 3 && !running && a.viewData.ShowImportHistory && !inputFocused {
+		if a.handleHistoryKey(key, &action) {
+			return action
+		}
+	}
+
+	// Query step of a SQLite import
+	if currentScreen == 3 && !running && a.viewData.SQLiteFile != "" {
+		if a.handleQueryKey(key, inputFocused, inputValue, &action) {
+			return action
+		}
+	}
+
+	// Column mapping step of a CSV import
+	if currentScreen == 3 && !running && a.viewData.CSVImport != nil {
+		if a.handleMappingKey(key, inputFocused, inputValue, &action) {
+			return action
+		}
+	}
+
+	// File browser
+	if currentScreen == 3 && !running && a.viewData.CSVImport == nil && a.viewData.ImportPlan == nil && !a.viewData.ShowImportHistory && a.viewData.SQLiteFile == "" {
+		if a.handleBrowserKey(key, inputFocused, selectedFile, files, &action) {
+			return action
+		}
+	}
 
 	switch key {
 	case "q", "ctrl+c":
 		action.ShouldQuit = true
 
 	case "1", "l":
-		if currentScreen != 2 || !inputFocused {
-			action.SetScreen = 0
-			action.ScreenChanged = true
-			action.BlurInput = true
-		}
+		action.SetScreen = 0
+		action.ScreenChanged = true
+		action.BlurInput = true
 
 	case "2", "s":
-		if currentScreen != 2 || !inputFocused {
-			action.SetScreen = 1
-			action.ScreenChanged = true
-			action.BlurInput = true
-		}
+		action.SetScreen = 1
+		action.ScreenChanged = true
+		action.BlurInput = true
 
 	case "3", "c":
-		if currentScreen != 2 || !inputFocused {
-			action.SetScreen = 2
-			action.ScreenChanged = true
-		}
+		action.SetScreen = 2
+		action.ScreenChanged = true
 
 	case "4", "i":
 		action.SetScreen = 3
@@ -64,23 +146,42 @@ func (a *App) HandleKeyPress(key string, currentScreen int, confirmStart bool, m
 		action.ScreenChanged = true
 		action.BlurInput = true
 
+	case "6", "v":
+		action.SetScreen = 5
+		action.ScreenChanged = true
+		action.BlurInput = true
+
+	case "7", "x":
+		action.SetScreen = 6
+		action.ScreenChanged = true
+		action.BlurInput = true
+
+	case "8", "/":
+		action.SetScreen = 7
+		action.ScreenChanged = true
+		action.FocusInput = true
+
+	case "d":
+		if currentScreen == 6 {
+			sel := a.viewData.SelectedSuppression
+			if sel >= 0 && sel < len(a.viewData.SuppressionEntries) {
+				action.RemoveSuppression = a.viewData.SuppressionEntries[sel]
+			}
+		}
+
 	case "r", "R":
 		if currentScreen == 0 {
 			action.ClearLogs = true
 		}
 
 	case "b", "B":
-		if currentScreen != 2 || !inputFocused {
-			if !mailStarted && !confirmStart {
-				action.ShowConfirm = true
-			}
+		if !mailStarted && !confirmStart {
+			action.ShowConfirm = true
 		}
 
 	case "a":
-		if currentScreen != 2 || !inputFocused {
-			if mailStarted {
-				action.StopMail = true
-			}
+		if mailStarted {
+			action.StopMail = true
 		}
 
 	case "y":
@@ -94,31 +195,43 @@ func (a *App) HandleKeyPress(key string, currentScreen int, confirmStart bool, m
 		}
 
 	case "up":
-		if currentScreen == 3 && len(files) > 0 && selectedFile > 0 {
-			action.FileSelected = selectedFile - 1
-		} else if currentScreen == 0 {
+		if currentScreen == 6 && a.viewData.SelectedSuppression > 0 {
+			action.SuppressionSelected = a.viewData.SelectedSuppression - 1
+		} else if currentScreen == 0 || currentScreen == 5 || currentScreen == 7 {
 			action.ScrollUp = true
 		}
 
 	case "down":
-		if currentScreen == 3 && len(files) > 0 && selectedFile < len(files)-1 {
-			action.FileSelected = selectedFile + 1
-		} else if currentScreen == 0 {
+		if currentScreen == 6 && a.viewData.SelectedSuppression < len(a.viewData.SuppressionEntries)-1 {
+			action.SuppressionSelected = a.viewData.SelectedSuppression + 1
+		} else if currentScreen == 0 || currentScreen == 5 || currentScreen == 7 {
 			action.ScrollDown = true
 		}
 
 	case "enter":
 		if currentScreen == 2 {
 			if inputFocused {
-				if val, err := strconv.Atoi(delayValue); err == nil && val > 0 {
+				if val, err := strconv.Atoi(inputValue); err == nil && val > 0 {
 					action.UpdateDelay = val
 				}
 				action.BlurInput = true
 			} else {
 				action.FocusInput = true
 			}
-		} else if currentScreen == 3 && len(files) > 0 && selectedFile >= 0 && selectedFile < len(files) {
-			action.ImportFile = files[selectedFile]
+		} else if currentScreen == 6 {
+			if inputFocused {
+				action.AddSuppression = inputValue
+				action.BlurInput = true
+			} else {
+				action.FocusInput = true
+			}
+		} else if currentScreen == 7 {
+			if inputFocused {
+				action.Search = inputValue
+				action.BlurInput = true
+			} else {
+				action.FocusInput = true
+			}
 		}
 
 	case "esc":
@@ -131,40 +244,215 @@ func (a *App) HandleKeyPress(key string, currentScreen int, confirmStart bool, m
 	return action
 }
 
-func (a *App) ImportEmailsFromFile(filename string) error {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return err
+// handleMappingKey: Keys of the CSV column mapping step. Returns false for
+// keys the step does not use so they keep their global meaning.
+func (a *App) handleMappingKey(key string, inputFocused bool, inputValue string, action *KeyAction) bool {
+	if inputFocused {
+		switch key {
+		case "enter":
+			if strings.TrimSpace(inputValue) == "" {
+				action.SkipColumn = true
+			} else {
+				action.MapColumn = inputValue
+			}
+			action.BlurInput = true
+			return true
+		case "esc":
+			action.BlurInput = true
+			return true
+		}
+		return false
 	}
 
-	emailRegex := regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
-	emails := emailRegex.FindAllString(string(data), -1)
-	a.addLog(fmt.Sprintf("Found %d emails in %s", len(emails), filename))
+	selected := a.viewData.SelectedColumn
+	switch key {
+	case "up":
+		if selected > 0 {
+			action.ColumnSelected = selected - 1
+		}
+	case "down":
+		if selected < len(a.viewData.CSVImport.Mapping)-1 {
+			action.ColumnSelected = selected + 1
+		}
+	case "e":
+		action.MapColumn = columnEmail
+	case "-":
+		action.SkipColumn = true
+	case "enter":
+		action.FocusInput = true
+	case "t":
+		// Query results always have a header and use commas
+		action.ToggleHeader = a.viewData.CSVImport.Query == ""
+	case "tab":
+		action.NextDelimiter = a.viewData.CSVImport.Query == ""
+	case "y":
+		action.CommitImport = true
+	case "n", "esc":
+		action.CancelImport = true
+	default:
+		return false
+	}
+	return true
+}
 
-	currentData, _ := os.ReadFile(a.cfg.Database.Path)
-	currentContent := string(currentData)
+// handleBrowserKey: Keys of the Import screen file browser, including the
+// name filter input
+func (a *App) handleBrowserKey(key string, inputFocused bool, selected int, files []ImportEntry, action *KeyAction) bool {
+	if inputFocused {
+		switch key {
+		case "enter":
+			action.BlurInput = true
+		case "esc":
+			action.BlurInput = true
+			action.ClearFilter = true
+		default:
+			return false
+		}
+		return true
+	}
 
-	f, err := os.OpenFile(a.cfg.Database.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	switch key {
+	case "up":
+		if selected > 0 {
+			action.FileSelected = selected - 1
+		}
+	case "down":
+		if selected < len(files)-1 {
+			action.FileSelected = selected + 1
+		}
+	case "enter":
+		if selected < 0 || selected >= len(files) {
+			return true
+		}
+		switch entry := files[selected]; {
+		case entry.IsDir:
+			action.OpenDir = entry.Path
+		case isCSVFile(entry.Path):
+			action.OpenCSV = entry.Path
+		case isSQLiteFile(entry.Path):
+			action.OpenSQLite = entry.Path
+		default:
+			action.ImportFile = entry.Path
+		}
+	case "backspace", "left":
+		action.OpenDir = filepath.Join(a.viewData.ImportDir, "..")
+	case "tab":
+		action.NextExtension = true
+	case "f":
+		action.FocusInput = true
+	case "esc":
+		if a.viewData.ImportFilter == "" {
+			return false
+		}
+		action.ClearFilter = true
+	case "u":
+		action.ShowHistory = true
+	default:
+		return false
 	}
-	defer f.Close()
+	return true
+}
+
+// handleQueryKey: Keys of the query step of a SQLite import
+func (a *App) handleQueryKey(key string, inputFocused bool, inputValue string, action *KeyAction) bool {
+	switch {
+	case inputFocused && key == "enter":
+		action.RunQuery = inputValue
+		action.BlurInput = true
+	case inputFocused && key == "esc":
+		action.BlurInput = true
+	case inputFocused:
+		return false
+	case key == "enter":
+		action.FocusInput = true
+	case key == "n" || key == "esc":
+		action.CancelQuery = true
+	default:
+		return false
+	}
+	return true
+}
 
-	added := 0
-	for _, email := range emails {
-		if !strings.Contains(currentContent, email+" PENDING") &&
-			!strings.Contains(currentContent, email+" DONE") &&
-			!strings.Contains(currentContent, email+" FAILED") {
-			f.WriteString(email + " PENDING\n")
-			added++
+// handleHistoryKey: Keys of the import history, including the undo
+// confirmation
+func (a *App) handleHistoryKey(key string, action *KeyAction) bool {
+	if a.viewData.ConfirmUndo {
+		switch key {
+		case "y":
+			action.UndoImport = true
+		case "n", "esc":
+			action.CancelUndo = true
+		default:
+			return false
 		}
+		return true
+	}
+
+	selected := a.viewData.SelectedImport
+	switch key {
+	case "up":
+		if selected > 0 {
+			action.ImportSelected = selected - 1
+		}
+	case "down":
+		if selected < min(len(a.viewData.ImportHistory), maxHistoryEntries)-1 {
+			action.ImportSelected = selected + 1
+		}
+	case "d":
+		if selected < len(a.viewData.ImportHistory) {
+			action.ConfirmUndo = true
+		}
+	case "esc", "u":
+		action.CloseHistory = true
+	default:
+		return false
+	}
+	return true
+}
+
+// ImportEmailsFromFile imports a text, vCard or JSON file without a preview
+func (a *App) ImportEmailsFromFile(filename string) error {
+	p := &ImportProgress{Source: filename}
+	plan, err := a.PlanFileImport(filename, p)
+	if err != nil {
+		return err
 	}
+	_, err = a.CommitImport(plan, p)
+	return err
+}
 
-	a.addLog(fmt.Sprintf("Imported %d emails from %s", added, filename))
-	a.updateStats()
-	a.addLog(fmt.Sprintf("New pending count: %d", a.stats.Pending))
+// PlanFileImport reads a text, vCard or JSON file and plans its import
+func (a *App) PlanFileImport(filename string, p *ImportProgress) (*ImportPlan, error) {
+	return a.planImport(filename, filename, a.fileRows(filename), p)
+}
 
-	return nil
+// fileRows: Streams the rows of a text, vCard or JSON file. Text files are
+// scanned a line at a time.
+func (a *App) fileRows(filename string) rowSource {
+	return func(p *ImportProgress, fn func(ImportRow) error) (int, error) {
+		file, err := p.open(filename)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+
+		if strings.EqualFold(filepath.Ext(filename), ".vcf") {
+			return 0, readVCards(file, fn)
+		}
+		if isJSONFile(filename) {
+			return readJSONRecipients(file, a.cfg.Import.JSONEmailPath, fn)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			for _, row := range parseAddressLine(scanner.Text()) {
+				if err := fn(row); err != nil {
+					return 0, err
+				}
+			}
+		}
+		return 0, scanner.Err()
+	}
 }
 
 func (a *App) ClearLogs() {
@@ -0,0 +1,22 @@
+package app
+
+import "testing"
+
+func TestHandleKeyPressTypesIntoFocusedInput(t *testing.T) {
+	a := &App{}
+	for _, screen := range []int{2, 6, 7} {
+		for _, key := range []string{"1", "s", "b", "a", "q"} {
+			action := a.HandleKeyPress(key, screen, false, true, true, 0, nil, "")
+			if action.ScreenChanged || action.ShowConfirm || action.StopMail || action.ShouldQuit {
+				t.Errorf("screen %d, key %q acted on a focused input: %+v", screen, key, action)
+			}
+		}
+	}
+
+	if action := a.HandleKeyPress("1", 2, false, false, false, 0, nil, ""); action.SetScreen != 0 {
+		t.Errorf("key 1 without focus: SetScreen %d, want 0", action.SetScreen)
+	}
+	if action := a.HandleKeyPress("a", 2, false, true, false, 0, nil, ""); !action.StopMail {
+		t.Error("key a without focus did not stop sending")
+	}
+}
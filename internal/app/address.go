@@ -0,0 +1,68 @@
+// address.go: Address normalisation and deduplication keys
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// gmailDomains ignore dots and +tags in the local part
+var gmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+}
+
+// normalizeAddress: Trims an address and converts its domain to lowercase
+// ASCII (IDNA), e.g. " Bob@Bücher.Example " -> "Bob@xn--bcher-kva.example".
+// The local part is kept as given.
+func normalizeAddress(email string) (string, error) {
+	email = cleanAddress(email)
+	i := strings.LastIndex(email, "@")
+	if i <= 0 || i == len(email)-1 {
+		return "", fmt.Errorf("%q is not an address", email)
+	}
+	local, domain := email[:i], strings.TrimSuffix(email[i+1:], ".")
+
+	ascii, err := idna.Lookup.ToASCII(strings.ToLower(domain))
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %q: %w", domain, err)
+	}
+	if ascii == "" {
+		return "", errors.New("empty domain")
+	}
+	return local + "@" + ascii, nil
+}
+
+// addressKey: Deduplication key of an address. Addresses are normalised;
+// with foldGmail, Gmail local parts are compared without dots, +tags and
+// case, and googlemail.com counts as gmail.com.
+func addressKey(email string, foldGmail bool) string {
+	normalized, err := normalizeAddress(email)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(email))
+	}
+	i := strings.LastIndex(normalized, "@")
+	local, domain := normalized[:i], normalized[i+1:]
+
+	if foldGmail && gmailDomains[domain] {
+		local = strings.ToLower(local)
+		if plus := strings.Index(local, "+"); plus >= 0 {
+			local = local[:plus]
+		}
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+// addressKeyFunc: addressKey with the configured Gmail folding
+func (a *App) addressKeyFunc() func(string) string {
+	fold := a.cfg.Import.FoldGmail
+	return func(email string) string {
+		return addressKey(email, fold)
+	}
+}
@@ -0,0 +1,45 @@
+package app
+
+import "testing"
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{" Bob@Example.COM ", "Bob@example.com", true},
+		{"<bob@example.com>", "bob@example.com", true},
+		{"mailto:bob@example.com", "bob@example.com", true},
+		{"bob@Bücher.Example", "bob@xn--bcher-kva.example", true},
+		{"bob@example.com.", "bob@example.com", true},
+		{"bob", "", false},
+		{"@example.com", "", false},
+		{"bob@", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeAddress(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("normalizeAddress(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestAddressKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		fold bool
+		same bool
+	}{
+		{"bob@example.com", "bob@EXAMPLE.com", false, true},
+		{"bob@example.com", "Bob@example.com", false, false},
+		{"bob@bücher.example", "bob@xn--bcher-kva.example", false, true},
+		{"b.o.b+news@gmail.com", "bob@googlemail.com", true, true},
+		{"b.o.b+news@gmail.com", "bob@gmail.com", false, false},
+		{"b.o.b@example.com", "bob@example.com", true, false},
+	}
+	for _, tt := range tests {
+		if same := addressKey(tt.a, tt.fold) == addressKey(tt.b, tt.fold); same != tt.same {
+			t.Errorf("addressKey(%q) == addressKey(%q) with fold %v: %v", tt.a, tt.b, tt.fold, same)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,21 +16,25 @@ import (
 const (
 	watcherSetupDelay  = 100 * time.Millisecond
 	dispatcherInterval = 1 * time.Second
+
+	// stuckSendingTimeout is how long a record may stay SENDING before the
+	// next start assumes the send was interrupted
+	stuckSendingTimeout = 5 * time.Minute
+
+	// maxPendingLines caps the Pending screen, which is rebuilt on every
+	// log line
+	maxPendingLines = 1000
 )
 
 // Init: Initializes the application, loads config, sets up watcher
 func (a *App) Init() error {
-	cfg, err := LoadConfig("config.yaml")
-	if err != nil {
-		return fmt.Errorf("failed to load config: %v", err)
+	if err := a.load(); err != nil {
+		return err
 	}
-	a.cfg = cfg
-
-	htmlBody, err := os.ReadFile(cfg.Mail.Template)
-	if err != nil {
-		return fmt.Errorf("failed to load template: %v", err)
+	if err := a.lockDatabase(); err != nil {
+		return err
 	}
-	a.htmlBody = htmlBody
+	cfg := a.cfg
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -35,11 +42,6 @@ func (a *App) Init() error {
 	}
 	a.Watcher = watcher
 
-	// Ensure data file exists
-	if err := InitDB(cfg.Database.Path); err != nil {
-		return fmt.Errorf("failed to init db: %v", err)
-	}
-
 	err = watcher.Add(cfg.Database.Path)
 	if err != nil {
 		return fmt.Errorf("failed to watch file: %v", err)
@@ -47,12 +49,12 @@ func (a *App) Init() error {
 	time.Sleep(watcherSetupDelay)
 
 	a.stopCh = make(chan bool, 1)
-	a.delaySeconds = cfg.Mail.DelaySeconds
+	a.done = make(chan struct{})
 	a.booted = false
 	a.logs = []string{"BulkMail TUI started...", "Initializing database...", "Setting up watcher...", "Loading configuration..."}
 
 	// Initialize viewData
-	a.viewData.TabNames = []string{"Logs", "Stats", "Preferences", "Import", "Pending"}
+	a.viewData.TabNames = []string{"Logs", "Stats", "Preferences", "Import", "Pending", "Preview", "Suppression", "Search"}
 	a.viewData.DelaySeconds = a.delaySeconds
 	a.viewData.IsRunning = false
 	a.viewData.StatusText = "STOPPED"
@@ -60,14 +62,80 @@ func (a *App) Init() error {
 	a.addLog("Updating initial stats...")
 	a.updateStats()
 
+	if err := a.startTracking(); err != nil {
+		return fmt.Errorf("failed to start tracking server: %v", err)
+	}
+
+	if err := a.startWatchDir(); err != nil {
+		return fmt.Errorf("failed to watch %s: %v", cfg.Import.WatchDir, err)
+	}
+
 	a.addLog("Starting dispatcher...")
 	a.startDispatcher()
+	a.startMailboxProcessor("Bounces", a.cfg.Bounces)
+	a.startMailboxProcessor("Complaints", a.cfg.Complaints.MailboxConfig)
 
 	a.addLog("Initialization complete!")
 
 	return nil
 }
 
+// InitHeadless: Loads and checks the config, templates and database for a
+// command run without the TUI. Logs are written to out. No watcher,
+// dispatcher, tracking server or mailbox processor is started.
+func (a *App) InitHeadless(out io.Writer) error {
+	a.logOut = out
+	if err := a.load(); err != nil {
+		return err
+	}
+	a.updateStats()
+	return nil
+}
+
+// load: Loads the config and templates, creates the database if missing and
+// checks the settings that cannot be used as given
+func (a *App) load() error {
+	cfg, err := LoadConfig(a.ConfigFile())
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	if a.DBPath != "" {
+		cfg.Database.Path = a.DBPath
+	}
+	a.cfg = cfg
+	a.delaySeconds = cfg.Mail.DelaySeconds
+
+	if err := a.loadTemplates(); err != nil {
+		return err
+	}
+	a.htmlBody = a.templates[templateKey("", "")]
+	if err := a.loadVariantState(); err != nil {
+		return err
+	}
+
+	// Ensure data file exists
+	if err := InitDB(cfg.Database.Path); err != nil {
+		return fmt.Errorf("failed to init db: %v", err)
+	}
+
+	if err := a.validateUnsubscribe(); err != nil {
+		return err
+	}
+	return validateReturnPath(cfg.SMTP.ReturnPath)
+}
+
+// loadTemplate: Reads an HTML template, inlining its CSS if configured
+func (a *App) loadTemplate(path string) ([]byte, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if a.cfg.Mail.InlineCSS {
+		body = []byte(inlineCSS(string(body)))
+	}
+	return body, nil
+}
+
 func (a *App) updateStats() {
 	stats, err := GetStats(a.cfg.Database.Path)
 	if err == nil {
@@ -75,10 +143,16 @@ func (a *App) updateStats() {
 		a.stats = *stats
 		a.mu.Unlock()
 		a.updateViewData()
+		a.checkVariantWinner(*stats)
+		a.checkComplaintRate(*stats)
 	}
 }
 
 func (a *App) updateViewData() {
+	// Headless commands have no screens
+	if a.logOut != nil {
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -104,7 +178,7 @@ func (a *App) updateViewData() {
 	copy(a.viewData.Logs, a.logs)
 
 	// Load pending emails
-	pendingEmails, err := GetPendingEmails(a.cfg.Database.Path)
+	pendingEmails, total, err := GetPendingEmails(a.cfg.Database.Path, maxPendingLines)
 	if err != nil {
 		a.viewData.PendingEmails = []PendingEmail{}
 	} else {
@@ -112,7 +186,7 @@ func (a *App) updateViewData() {
 	}
 
 	// Prepare tab names
-	a.viewData.TabNames = []string{"Logs", "Stats", "Preferences", "Import", "Pending"}
+	a.viewData.TabNames = []string{"Logs", "Stats", "Preferences", "Import", "Pending", "Preview", "Suppression", "Search"}
 
 	// Prepare logs content with colors
 	logs := a.viewData.Logs
@@ -155,25 +229,227 @@ func (a *App) updateViewData() {
 	}
 
 	// Prepare stats content
-	a.viewData.StatsContent = fmt.Sprintf("Statistics:\nTotal: %d\nPending: %d\nSending: %d\nSent: %d\nFailed: %d\n",
-		a.viewData.Stats.Total,
-		a.viewData.Stats.Pending,
-		a.viewData.Stats.Sending,
-		a.viewData.Stats.Sent,
-		a.viewData.Stats.Failed)
+	a.viewData.StatsContent = a.statsContent(a.viewData.Stats)
 
 	// Prepare pending content
-	a.viewData.PendingContent = "Pending Emails:\n\n"
+	var pending strings.Builder
+	pending.WriteString("Pending Emails:\n\n")
 	for _, pendingEmail := range a.viewData.PendingEmails {
 		if pendingEmail.IsSending {
-			a.viewData.PendingContent += "⏳ " + pendingEmail.Email + "\n"
+			pending.WriteString("⏳ " + pendingEmail.Email + "\n")
 		} else {
-			a.viewData.PendingContent += "📧 " + pendingEmail.Email + "\n"
+			pending.WriteString("📧 " + pendingEmail.Email + "\n")
+		}
+	}
+	if more := total - len(a.viewData.PendingEmails); more > 0 {
+		fmt.Fprintf(&pending, "... and %d more\n", more)
+	}
+	a.viewData.PendingContent = pending.String()
+}
+
+// statsContent: Renders the Stats screen. The caller holds a.mu.
+func (a *App) statsContent(stats Stats) string {
+	content := fmt.Sprintf("Statistics:\nTotal: %d\nPending: %d\nSending: %d\nSent: %d\nFailed: %d\nUnsubscribed: %d\nSuppressed: %d\nBounced: %d (%d hard)\nComplained: %d (%.2f%% of delivered)\nInvalid: %d\n",
+		stats.Total,
+		stats.Pending,
+		stats.Sending,
+		stats.Sent,
+		stats.Failed,
+		stats.Unsubscribed,
+		stats.Suppressed,
+		stats.Bounced,
+		stats.HardBounced,
+		stats.Complained,
+		complaintRate(stats),
+		stats.Invalid)
+
+	if len(a.cfg.Mail.Variants) > 0 {
+		content += "\nVariants:\n"
+		for _, v := range a.cfg.Mail.Variants {
+			vs := stats.Variants[v.Name]
+			if vs == nil {
+				vs = &VariantStats{}
+			}
+			line := fmt.Sprintf("  %s: Sent: %d, Failed: %d", v.Name, vs.Sent, vs.Failed)
+			if a.cfg.Tracking.Opens {
+				line += fmt.Sprintf(", Opened: %d", vs.Opened)
+			}
+			if a.cfg.Tracking.Clicks {
+				line += fmt.Sprintf(", Clicked: %d", vs.Clicked)
+			}
+			if v.Name == a.variantWinner {
+				line += " (winner)"
+			}
+			content += line + "\n"
+		}
+	}
+
+	if a.cfg.Tracking.Opens {
+		rate := 0.0
+		if stats.Delivered > 0 {
+			rate = float64(stats.Opened) / float64(stats.Delivered) * 100
+		}
+		content += fmt.Sprintf("\nOpened: %d recipients (%.1f%% of delivered), %d opens total\n", stats.Opened, rate, stats.Opens)
+		content += "  Note: approximate. Apple Mail Privacy Protection and image proxies\n  load the pixel without a human reading the mail, inflating this rate.\n"
+	}
+
+	if a.cfg.Tracking.Clicks {
+		content += fmt.Sprintf("\nClicked: %d recipients\n", stats.Clicked)
+		ids := make([]string, 0, len(stats.Links))
+		for id := range stats.Links {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return stats.Links[ids[i]].Total > stats.Links[ids[j]].Total
+		})
+		for _, id := range ids {
+			ls := stats.Links[id]
+			link := a.links[id]
+			if link == "" {
+				link = id + " (not in current templates)"
+			}
+			if len(link) > 50 {
+				link = link[:50] + "..."
+			}
+			content += fmt.Sprintf("  %s: Unique: %d, Total: %d\n", link, ls.Unique, ls.Total)
+		}
+	}
+
+	if len(stats.Languages) > 0 {
+		content += "\nLanguages:\n"
+		langs := make([]string, 0, len(stats.Languages))
+		for lang := range stats.Languages {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+		for _, lang := range langs {
+			ls := stats.Languages[lang]
+			line := fmt.Sprintf("  %s: Total: %d, Pending: %d, Sent: %d, Failed: %d", lang, ls.Total, ls.Pending, ls.Sent, ls.Failed)
+			if resolved := a.recipientLang(&Recipient{Fields: map[string]string{FieldLang: lang}}); resolved != lang {
+				line += fmt.Sprintf(" (falls back to %s)", firstNonEmpty(resolved, "default template"))
+			}
+			content += line + "\n"
+		}
+		unset := stats.Total
+		for _, ls := range stats.Languages {
+			unset -= ls.Total
+		}
+		if unset > 0 {
+			content += fmt.Sprintf("  (no lang): %d\n", unset)
+		}
+	}
+	return content
+}
+
+// previewContent: Renders the message the next pending recipient would get
+func (a *App) previewContent() string {
+	a.mu.Lock()
+	email := "preview@example.com"
+	if len(a.viewData.PendingEmails) > 0 {
+		email = a.viewData.PendingEmails[0].Email
+	}
+	a.mu.Unlock()
+
+	r := &Recipient{Email: email, Variant: pickVariant(email, a.activeVariants())}
+	subject, body := a.messageFor(r)
+
+	content := "Preview for " + email
+	if r.Variant != "" {
+		content += " (variant " + r.Variant + ")"
+	}
+	if lang := a.recipientLang(r); lang != "" {
+		content += " [" + lang + "]"
+	}
+	if a.cfg.Mail.InlineCSS {
+		content += " - CSS inlined"
+	}
+	content += "\nSubject: " + personalize(subject, r, false) + "\n\n" + a.renderBody(body, r)
+	return content
+}
+
+// searchContent: Finds records by address or Message-ID for the Search tab
+func (a *App) searchContent(query string) string {
+	const limit = 200
+	results, err := SearchRecords(a.cfg.Database.Path, query, limit)
+	if err != nil {
+		return fmt.Sprintf("Search error: %v", err)
+	}
+
+	content := fmt.Sprintf("Results for %q: %d", query, len(results))
+	if len(results) == limit {
+		content += fmt.Sprintf(" (first %d)", limit)
+	}
+	content += "\n\n"
+	for _, r := range results {
+		date := "-"
+		if !r.Timestamp.IsZero() {
+			date = r.Timestamp.Format("2006-01-02 15:04:05")
+		}
+		content += fmt.Sprintf("%s  %-12s %s\n", date, r.Status, r.Email)
+		if id := r.Fields[FieldMessageID]; id != "" {
+			content += "    Message-ID: " + id + "\n"
+		}
+		if r.Error != "" {
+			content += "    Error: " + r.Error + "\n"
 		}
 	}
+	return content
+}
+
+// renderBody: Fills template placeholders for a recipient, rewrites links
+// for click tracking and adds the open tracking pixel
+func (a *App) renderBody(htmlBody string, r *Recipient) string {
+	if a.cfg.Tracking.Clicks {
+		htmlBody = rewriteLinks(htmlBody, func(link string) string {
+			return a.clickURL(r.Email, link)
+		})
+	}
+	htmlBody = personalize(htmlBody, r, true)
+	if link := a.unsubscribeURL(r.Email); link != "" {
+		htmlBody = strings.ReplaceAll(htmlBody, "{{unsubscribe_url}}", link)
+	}
+	if a.cfg.Tracking.Opens {
+		htmlBody = a.injectPixel(htmlBody, r.Email)
+	}
+	return htmlBody
+}
+
+// mailOptions: Per-recipient header values for SendMail
+func (a *App) mailOptions(r *Recipient) MailOptions {
+	opts := MailOptions{
+		UnsubscribeURL:    a.unsubscribeURL(r.Email),
+		UnsubscribeMailto: a.unsubscribeMailto(r.Email),
+		MessageID:         r.Fields[FieldMessageID],
+	}
+	if a.cfg.SMTP.ReturnPath != "" {
+		opts.EnvelopeFrom = EncodeVERP(a.cfg.SMTP.ReturnPath, r.Email)
+	}
+	return opts
+}
+
+// Close: Stops background workers, the watcher and the tracking server
+func (a *App) Close() {
+	if a.done != nil {
+		close(a.done)
+	}
+	if a.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), trackingShutdownTimeout)
+		defer cancel()
+		a.server.Shutdown(ctx)
+	}
+	if a.Watcher != nil {
+		a.Watcher.Close()
+	}
+	if a.dbLock != nil {
+		a.dbLock.Close()
+	}
 }
 
 func (a *App) addLog(log string) {
+	if a.logOut != nil {
+		fmt.Fprintln(a.logOut, log)
+		return
+	}
 	a.mu.Lock()
 	a.logs = append(a.logs, log)
 	a.mu.Unlock()
@@ -183,6 +459,10 @@ func (a *App) addLog(log string) {
 }
 
 func (a *App) updateLastLog(log string) {
+	if a.logOut != nil {
+		fmt.Fprintln(a.logOut, log)
+		return
+	}
 	a.mu.Lock()
 	if len(a.logs) > 0 {
 		a.logs[len(a.logs)-1] = log
@@ -201,7 +481,7 @@ func (a *App) startDispatcher() {
 		a.addLog("Dispatcher started")
 
 		// Reset stuck SENDING records on startup
-		count, err := ResetStuckSending(a.cfg.Database.Path, 5*time.Minute)
+		count, err := ResetStuckSending(a.cfg.Database.Path, stuckSendingTimeout)
 		if err != nil {
 			a.addLog(fmt.Sprintf("ResetStuckSending error: %v", err))
 		} else if count > 0 {
@@ -227,26 +507,8 @@ func (a *App) startDispatcher() {
 				}
 
 				a.addLog("Checking for pending emails...")
-				recipient, err := GetNextPending(a.cfg.Database.Path)
-				if err != nil {
-					if !errors.Is(err, ErrNoPendingRecipients) {
-						a.updateLastLog(fmt.Sprintf("GetNextPending error: %v", err))
-						a.noPendingCount = 0
-					} else {
-						a.noPendingCount++
-						a.updateLastLog(fmt.Sprintf("No pending emails found (%d/3)", a.noPendingCount))
-						if a.noPendingCount >= 3 {
-							a.mu.Lock()
-							a.booted = false
-							a.mu.Unlock()
-							a.noPendingCount = 0
-							a.addLog("No pending emails after 3 checks, switching to STOPPED")
-							a.updateStats()
-						}
-					}
-					continue
-				}
-				if recipient == nil {
+				recipient, err := a.claimRecipient()
+				if errors.Is(err, ErrNoPendingRecipients) {
 					a.noPendingCount++
 					a.updateLastLog(fmt.Sprintf("No pending emails found (%d/3)", a.noPendingCount))
 					if a.noPendingCount >= 3 {
@@ -259,44 +521,39 @@ func (a *App) startDispatcher() {
 					}
 					continue
 				}
-
-				a.noPendingCount = 0
-				a.updateLastLog(fmt.Sprintf("Found pending email: %s", recipient.Email))
-
-				// Check last sent time and calculate delay
-				lastSentTime, err := GetLastSentTime(a.cfg.Database.Path)
-				if err == nil && !lastSentTime.IsZero() {
-					elapsed := time.Since(lastSentTime)
-					delay := time.Duration(a.delaySeconds) * time.Second
-
-					if elapsed < delay {
-						waitTime := delay - elapsed
-						a.addLog(fmt.Sprintf("Last email sent %.0f seconds ago, waiting %.0f more seconds...", elapsed.Seconds(), waitTime.Seconds()))
-						time.Sleep(waitTime)
-					} else {
-						a.addLog(fmt.Sprintf("Last email sent %.0f seconds ago, proceeding immediately", elapsed.Seconds()))
+				if errors.Is(err, errVariantHold) {
+					// Not the end of the work: recheck the stats now and
+					// then so the winner gets picked
+					a.noPendingCount = 0
+					a.updateLastLog(err.Error())
+					if time.Since(a.variantChecked) >= time.Minute {
+						a.variantChecked = time.Now()
+						a.updateStats()
 					}
-				} else {
-					a.addLog("No previous emails sent, proceeding immediately")
+					continue
 				}
-
-				a.addLog(fmt.Sprintf("Sending email to %s...", recipient.Email))
-				err = SendMail(a.cfg, recipient.Email, a.cfg.Mail.Subject, string(a.htmlBody))
-
 				if err != nil {
-					a.addLog(fmt.Sprintf("Error sending to %s: %v", recipient.Email, err))
-					if updateErr := UpdateStatus(a.cfg.Database.Path, recipient.Email, StatusFailed, err.Error()); updateErr != nil {
-						a.addLog(fmt.Sprintf("UpdateStatus error: %v", updateErr))
-					}
-				} else {
-					a.addLog(fmt.Sprintf("✓ Sent to %s", recipient.Email))
-					if updateErr := UpdateStatus(a.cfg.Database.Path, recipient.Email, StatusDone, ""); updateErr != nil {
-						a.addLog(fmt.Sprintf("UpdateStatus error: %v", updateErr))
-					}
+					a.updateLastLog(err.Error())
+					a.noPendingCount = 0
+					continue
 				}
-				a.updateStats()
+
+				a.noPendingCount = 0
+				a.updateLastLog(fmt.Sprintf("Found pending email: %s", recipient.Email))
+				time.Sleep(a.sendDelay())
+				a.sendTo(recipient)
 			case event := <-a.Watcher.Events:
+				if a.isDropEvent(event) {
+					a.handleDropEvent(event)
+					continue
+				}
 				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					if a.committing.Load() > 0 {
+						// Every chunk of an import would trigger a full
+						// pass; the import makes one when done
+						a.missedChange.Store(true)
+						continue
+					}
 					a.addLog("Database file changed, updating...")
 					if err := a.UpdateDataFile(a.cfg.Database.Path); err != nil {
 						a.addLog(fmt.Sprintf("UpdateDataFile error: %v", err))
@@ -311,27 +568,96 @@ func (a *App) startDispatcher() {
 	}()
 }
 
-func (a *App) UpdateDataFile(path string) error {
-	data, err := os.ReadFile(path)
+// claimRecipient: Claims the next pending recipient as SENDING, marking
+// (and logging) suppressed ones on the way. Returns ErrNoPendingRecipients
+// when there is none, and errVariantHold while the A/B winner is awaited.
+func (a *App) claimRecipient() (*Recipient, error) {
+	if a.variantHolding() {
+		return nil, errVariantHold
+	}
+	suppressions, err := a.cachedSuppressions()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("LoadSuppressions error: %v", err)
+	}
+	recipient, err := GetNextPending(a.cfg.Database.Path, ClaimOptions{
+		Variants:     a.activeVariants(),
+		Suppressions: suppressions,
+		OnSuppressed: func(email string, entry SuppressionEntry) {
+			a.addLog(fmt.Sprintf("Skipped %s: suppressed by %s (%s)", email, entry.Entry, entry.Reason))
+		},
+		MessageID: func() (string, error) { return NewMessageID(a.cfg.SMTP.FromEmail) },
+	})
+	if errors.Is(err, ErrNoPendingRecipients) || (err == nil && recipient == nil) {
+		return nil, ErrNoPendingRecipients
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetNextPending error: %v", err)
 	}
-	lines := strings.Split(string(data), "\n")
-	changed := false
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	return recipient, nil
+}
+
+// sendDelay: Time left until the configured delay since the last message
+// has passed
+func (a *App) sendDelay() time.Duration {
+	lastSentTime, err := GetLastSentTime(a.cfg.Database.Path)
+	if err != nil || lastSentTime.IsZero() {
+		a.addLog("No previous emails sent, proceeding immediately")
+		return 0
+	}
+	elapsed := time.Since(lastSentTime)
+	delay := time.Duration(a.delaySeconds) * time.Second
+	if elapsed < delay {
+		waitTime := delay - elapsed
+		a.addLog(fmt.Sprintf("Last email sent %.0f seconds ago, waiting %.0f more seconds...", elapsed.Seconds(), waitTime.Seconds()))
+		return waitTime
+	}
+	a.addLog(fmt.Sprintf("Last email sent %.0f seconds ago, proceeding immediately", elapsed.Seconds()))
+	return 0
+}
+
+// sendTo: Sends the message of a claimed recipient and records the outcome
+// as DONE or FAILED
+func (a *App) sendTo(recipient *Recipient) error {
+	subject, body := a.messageFor(recipient)
+	subject = personalize(subject, recipient, false)
+	if recipient.Variant != "" {
+		a.addLog(fmt.Sprintf("Sending email to %s (variant %s)...", recipient.Email, recipient.Variant))
+	} else {
+		a.addLog(fmt.Sprintf("Sending email to %s...", recipient.Email))
+	}
+	err := SendMail(a.cfg, recipient.Email, subject, a.renderBody(body, recipient), a.mailOptions(recipient))
+
+	if err != nil {
+		a.addLog(fmt.Sprintf("Error sending to %s: %v", recipient.Email, err))
+		if updateErr := UpdateStatus(a.cfg.Database.Path, recipient.Email, StatusFailed, err.Error()); updateErr != nil {
+			a.addLog(fmt.Sprintf("UpdateStatus error: %v", updateErr))
 		}
-		if !strings.Contains(line, ";") && strings.Contains(line, "@") {
-			lines[i] = "0000-00-00T00:00:00Z ; " + StatusPending + " ; " + line
-			changed = true
-			a.addLog(fmt.Sprintf("Converted: %s", line))
+	} else {
+		a.addLog(fmt.Sprintf("✓ Sent to %s", recipient.Email))
+		if updateErr := UpdateStatus(a.cfg.Database.Path, recipient.Email, StatusDone, ""); updateErr != nil {
+			a.addLog(fmt.Sprintf("UpdateStatus error: %v", updateErr))
 		}
 	}
-	if changed {
+	a.updateStats()
+	return err
+}
+
+func (a *App) UpdateDataFile(path string) error {
+	// DNS checks would hold the database lock, so only offline checks run here
+	validator := a.newValidator()
+	validator.Resolver = nil
+	converted, dropped, err := ConvertBareLines(path, a.addressKeyFunc(), validator.Check)
+	if err != nil {
+		return err
+	}
+	for _, email := range converted {
+		a.addLog(fmt.Sprintf("Converted: %s", email))
+	}
+	for _, email := range dropped {
+		a.addLog(fmt.Sprintf("Removed duplicate: %s", email))
+	}
+	if len(converted) > 0 {
 		a.addLog("Data file updated with new pending entries.")
-		return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
 	}
 	return nil
 }
@@ -0,0 +1,293 @@
+// bounce.go: Processes asynchronous bounces (RFC 3464 delivery status
+// notifications and common non-standard formats) from a local mailbox
+
+package app
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultMailboxInterval = 5 * time.Minute
+
+// Bounce classifications stored in the "bounce" record field
+const (
+	BounceHard = "hard"
+	BounceSoft = "soft"
+)
+
+var (
+	enhancedStatusRegex = regexp.MustCompile(`\b([45])\.(\d{1,3})\.(\d{1,3})\b`)
+	successStatusRegex  = regexp.MustCompile(`\b2\.\d{1,3}\.\d{1,3}\b`)
+	smtpReplyRegex      = regexp.MustCompile(`\b([45]\d\d)[ -]`)
+	bounceSubjectRegex  = regexp.MustCompile(`(?i)undeliver|delivery (status notification|failure|has failed)|returned mail|mail delivery failed|failure notice|delivery problem`)
+	bounceSenderRegex   = regexp.MustCompile(`(?i)mailer-daemon|postmaster`)
+	bodyMessageIDRegex  = regexp.MustCompile(`(?im)^Message-ID:\s*(<[^>]+>)`)
+)
+
+// Bounce is a parsed delivery failure for one recipient
+type Bounce struct {
+	Recipient  string
+	MessageID  string
+	Status     string
+	Diagnostic string
+	Kind       string
+}
+
+// parseBounces: Extracts the failed recipients of a bounce message. Returns
+// nothing for messages that are not bounces or only report delays/successes.
+func parseBounces(msg *mail.Message) []Bounce {
+	mediaType, params, parts := messageParts(msg)
+
+	var messageID string
+	for _, p := range parts {
+		if p.mediaType == "message/rfc822" || p.mediaType == "text/rfc822-headers" {
+			if h := embeddedHeaders(p.body); h != nil {
+				messageID = strings.TrimSpace(h.Get("Message-Id"))
+			}
+		}
+	}
+	if mediaType == "multipart/report" && strings.EqualFold(params["report-type"], "delivery-status") {
+		for _, p := range parts {
+			if p.mediaType == "message/delivery-status" || p.mediaType == "message/global-delivery-status" {
+				return dsnBounces(p.body, messageID)
+			}
+		}
+	}
+	return heuristicBounces(msg, parts, messageID)
+}
+
+// dsnBounces: Reads per-recipient blocks of an RFC 3464 delivery-status part
+func dsnBounces(data []byte, messageID string) []Bounce {
+	var bounces []Bounce
+	for _, block := range parseHeaderBlocks(data) {
+		recipient := stripAddressType(firstNonEmpty(block.Get("Final-Recipient"), block.Get("Original-Recipient")))
+		if recipient == "" {
+			// Per-message block
+			continue
+		}
+		action := strings.ToLower(strings.TrimSpace(block.Get("Action")))
+		if action != "failed" {
+			continue
+		}
+
+		status := strings.TrimSpace(block.Get("Status"))
+		if strings.HasPrefix(status, "2.") {
+			// Delivered after all, whatever the action says
+			continue
+		}
+		if m := enhancedStatusRegex.FindString(status); m != "" {
+			status = m
+		}
+		diagnostic := stripAddressType(block.Get("Diagnostic-Code"))
+		bounces = append(bounces, Bounce{
+			Recipient:  recipient,
+			MessageID:  messageID,
+			Status:     status,
+			Diagnostic: diagnostic,
+			Kind:       classifyBounce(status, diagnostic),
+		})
+	}
+	return bounces
+}
+
+// heuristicBounces: Handles non-standard bounces (qmail, older Exim,
+// provider-specific notices) by looking at headers and body text. The
+// recipient comes from X-Failed-Recipients only; otherwise it is left empty
+// for the VERP return path or the Message-ID of the sent record to identify.
+func heuristicBounces(msg *mail.Message, parts []mimePart, messageID string) []Bounce {
+	from := msg.Header.Get("From")
+	subject := msg.Header.Get("Subject")
+	failed := msg.Header.Get("X-Failed-Recipients")
+	if failed == "" && !bounceSenderRegex.MatchString(from) && !bounceSubjectRegex.MatchString(subject) {
+		return nil
+	}
+
+	var text string
+	for _, p := range parts {
+		if strings.HasPrefix(p.mediaType, "text/plain") {
+			text = string(p.body)
+			break
+		}
+	}
+	if messageID == "" {
+		if m := bodyMessageIDRegex.FindStringSubmatch(text); m != nil {
+			messageID = m[1]
+		}
+	}
+
+	var recipients []string
+	for _, addr := range strings.Split(failed, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			recipients = append(recipients, addr)
+		}
+	}
+	if len(recipients) == 0 {
+		// Addresses in the text may be anyone's (a forwarding target, a
+		// quoted signature), so without the header the record is only
+		// found by the Message-ID or the VERP return path
+		recipients = []string{""}
+	}
+
+	status := enhancedStatusRegex.FindString(text)
+	if status == "" {
+		if m := smtpReplyRegex.FindStringSubmatch(text); m != nil {
+			status = m[1][:1] + ".0.0"
+		}
+	}
+	if status == "" && successStatusRegex.MatchString(text) {
+		// A success notice, such as a delivery receipt
+		return nil
+	}
+	diagnostic := ""
+	for _, line := range strings.Split(text, "\n") {
+		if smtpReplyRegex.MatchString(line) || enhancedStatusRegex.MatchString(line) {
+			diagnostic = strings.TrimSpace(line)
+			break
+		}
+	}
+
+	var bounces []Bounce
+	for _, r := range recipients {
+		bounces = append(bounces, Bounce{
+			Recipient:  r,
+			MessageID:  messageID,
+			Status:     status,
+			Diagnostic: diagnostic,
+			Kind:       classifyBounce(status, diagnostic),
+		})
+	}
+	return bounces
+}
+
+// classifyBounce: 5.x.x is permanent except for conditions that usually
+// clear up (mailbox full, message too large for now, expired delivery).
+// Without a status, a 5xx reply in the diagnostic is permanent. Anything
+// else is soft, so an unreadable bounce never suppresses an address.
+func classifyBounce(status, diagnostic string) string {
+	switch {
+	case strings.HasPrefix(status, "4."):
+		return BounceSoft
+	case status == "5.2.2", status == "5.4.7", status == "5.3.4":
+		return BounceSoft
+	case strings.HasPrefix(status, "5."):
+		return BounceHard
+	}
+	if status != "" {
+		return BounceSoft
+	}
+	lower := strings.ToLower(diagnostic)
+	if strings.Contains(lower, "quota") || strings.Contains(lower, "mailbox full") || strings.Contains(lower, "try again") {
+		return BounceSoft
+	}
+	if m := smtpReplyRegex.FindStringSubmatch(diagnostic + " "); m != nil && m[1][0] == '5' {
+		return BounceHard
+	}
+	return BounceSoft
+}
+
+// ProcessMailbox: Reads new messages from a bounce or complaint mailbox.
+// Feedback-loop reports (ARF) are handled as complaints, everything else as
+// a potential bounce, so one mailbox can receive both.
+func (a *App) ProcessMailbox(name string, mb MailboxConfig) error {
+	if mb.Maildir == "" && mb.Mbox == "" {
+		return nil
+	}
+
+	hard, soft, complaints, unmatched := 0, 0, 0, 0
+	messages, err := readMailbox(mb.Maildir, mb.Mbox, func(msg *mail.Message) error {
+		verp := verpRecipient(a.cfg.SMTP.ReturnPath, msg.Header)
+
+		if complaint, ok := parseComplaint(msg, a.cfg.SMTP.ReturnPath); ok {
+			if complaint.Recipient == "" {
+				complaint.Recipient = verp
+			}
+			count, err := a.applyComplaint(complaint)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				unmatched++
+			} else {
+				complaints++
+			}
+			return nil
+		}
+
+		for _, bounce := range parseBounces(msg) {
+			// The VERP address identifies who we sent to, even when the
+			// DSN reports a forwarded or rewritten final recipient
+			if verp != "" {
+				bounce.Recipient = verp
+			}
+			count, err := a.applyBounce(bounce)
+			if err != nil {
+				return err
+			}
+			switch {
+			case count == 0:
+				unmatched++
+			case bounce.Kind == BounceHard:
+				hard++
+			default:
+				soft++
+			}
+		}
+		return nil
+	})
+	if messages > 0 {
+		a.addLog(fmt.Sprintf("%s: %d messages, %d hard bounces, %d soft bounces, %d complaints, %d unmatched",
+			name, messages, hard, soft, complaints, unmatched))
+		a.updateStats()
+	}
+	return err
+}
+
+// applyBounce: Marks the bounced recipient and suppresses hard bounces
+func (a *App) applyBounce(b Bounce) (int, error) {
+	match := BounceMatch{MessageID: b.MessageID, Email: b.Recipient}
+	count, email, err := MarkBounced(a.cfg.Database.Path, match, b.Kind, firstNonEmpty(b.Diagnostic, b.Status))
+	if err != nil || count == 0 {
+		return count, err
+	}
+
+	if b.Kind == BounceHard {
+		reason := "hard bounce"
+		if b.Status != "" {
+			reason += " " + b.Status
+		}
+		if err := AddSuppression(a.suppressionPath(), email, reason); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// startMailboxProcessor: Polls a bounce or complaint mailbox in the background
+func (a *App) startMailboxProcessor(name string, mb MailboxConfig) {
+	if mb.Maildir == "" && mb.Mbox == "" {
+		return
+	}
+	interval := defaultMailboxInterval
+	if mb.IntervalSeconds > 0 {
+		interval = time.Duration(mb.IntervalSeconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := a.ProcessMailbox(name, mb); err != nil {
+				a.addLog(fmt.Sprintf("ProcessMailbox %s error: %v", name, err))
+			}
+			select {
+			case <-a.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
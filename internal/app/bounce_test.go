@@ -0,0 +1,233 @@
+package app
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDSN = "From: MAILER-DAEMON@mx.example.com\r\n" +
+	"To: bounces@sender.example\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; gone@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; full@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.2.2\r\n" +
+	"Diagnostic-Code: smtp; 552 5.2.2 Mailbox full\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; relayed@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 2.0.0\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; slow@example.com\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.4.1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"Message-ID: <abc@sender.example>\r\n" +
+	"Subject: Newsletter\r\n" +
+	"--b1--\r\n"
+
+func readTestMessage(t *testing.T, raw string) *mail.Message {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestParseBouncesDSN(t *testing.T) {
+	bounces := parseBounces(readTestMessage(t, testDSN))
+	want := []Bounce{
+		{Recipient: "gone@example.com", MessageID: "<abc@sender.example>", Status: "5.1.1", Diagnostic: "550 5.1.1 User unknown", Kind: BounceHard},
+		{Recipient: "full@example.com", MessageID: "<abc@sender.example>", Status: "5.2.2", Diagnostic: "552 5.2.2 Mailbox full", Kind: BounceSoft},
+	}
+	if len(bounces) != len(want) {
+		t.Fatalf("parseBounces = %+v, want %+v", bounces, want)
+	}
+	for i := range want {
+		if bounces[i] != want[i] {
+			t.Errorf("bounce %d = %+v, want %+v", i, bounces[i], want[i])
+		}
+	}
+}
+
+func TestParseBouncesHeuristic(t *testing.T) {
+	msg := readTestMessage(t, "From: MAILER-DAEMON@mx.example.com\r\n"+
+		"Subject: failure notice\r\n"+
+		"\r\n"+
+		"Sorry, we were unable to deliver your message to the following address.\r\n"+
+		"\r\n"+
+		"<lost@example.com>:\r\n"+
+		"550 mailbox unavailable\r\n"+
+		"\r\n"+
+		"Message-ID: <xyz@sender.example>\r\n")
+	bounces := parseBounces(msg)
+	if len(bounces) != 1 {
+		t.Fatalf("parseBounces = %+v, want one bounce", bounces)
+	}
+	b := bounces[0]
+	if b.Recipient != "" || b.MessageID != "<xyz@sender.example>" || b.Status != "5.0.0" || b.Kind != BounceHard {
+		t.Errorf("bounce = %+v, want the Message-ID and no recipient from the text", b)
+	}
+
+	msg = readTestMessage(t, "From: MAILER-DAEMON@mx.example.com\r\n"+
+		"Subject: Mail delivery failed\r\n"+
+		"X-Failed-Recipients: lost@example.com, gone@example.com\r\n"+
+		"\r\n"+
+		"Forwarded to other@example.com: 550 5.1.1 no such user\r\n")
+	bounces = parseBounces(msg)
+	if len(bounces) != 2 || bounces[0].Recipient != "lost@example.com" || bounces[1].Recipient != "gone@example.com" {
+		t.Errorf("parseBounces = %+v, want the X-Failed-Recipients", bounces)
+	}
+
+	// Neither is a success notice
+	msg = readTestMessage(t, "From: MAILER-DAEMON@mx.example.com\r\n"+
+		"Subject: Delivery Status Notification (Success)\r\n"+
+		"\r\n"+
+		"<ann@example.com>: 2.0.0 OK, delivered\r\n"+
+		"\r\n"+
+		"Message-ID: <xyz@sender.example>\r\n")
+	if bounces := parseBounces(msg); len(bounces) != 0 {
+		t.Errorf("parseBounces(success notice) = %+v, want none", bounces)
+	}
+
+	// Ordinary mail is not a bounce
+	msg = readTestMessage(t, "From: someone@example.com\r\nSubject: Hello\r\n\r\nWrite to me at me@example.com\r\n")
+	if bounces := parseBounces(msg); len(bounces) != 0 {
+		t.Errorf("parseBounces(ordinary mail) = %+v, want none", bounces)
+	}
+}
+
+func TestProcessMailboxHeuristicMatch(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; DONE ; lost@example.com ;  ; message_id=<xyz@sender.example>",
+		"2026-01-01T00:00:00Z ; DONE ; other@example.com",
+		"2026-01-01T00:00:00Z ; DONE ; verp@example.com",
+	)
+	a.cfg.SMTP.ReturnPath = "bounces+{token}@sender.example"
+	bounce := func(to, id string) string {
+		return "From MAILER-DAEMON Mon Jan  1 00:00:00 2026\n" +
+			"From: MAILER-DAEMON@mx.example.com\n" +
+			"To: " + to + "\n" +
+			"Subject: failure notice\n" +
+			"\n" +
+			"<other@example.com>:\n" +
+			"550 mailbox unavailable\n" +
+			id +
+			"\n"
+	}
+	path := writeTestFile(t, a, "bounces.mbox",
+		bounce("bounces@sender.example", "\nMessage-ID: <xyz@sender.example>\n")+
+			bounce("bounces+verp=example.com@sender.example", "")+
+			bounce("bounces@sender.example", "\nMessage-ID: <unknown@sender.example>\n"))
+
+	if err := a.ProcessMailbox("Bounces", MailboxConfig{Mbox: path}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"lost@example.com":  StatusBounced,
+		"other@example.com": StatusDone,
+		"verp@example.com":  StatusBounced,
+	}
+	for _, r := range readTestRecords(t, a) {
+		if r.Status != want[r.Email] {
+			t.Errorf("%s: %s, want %s", r.Email, r.Status, want[r.Email])
+		}
+	}
+}
+
+func TestClassifyBounce(t *testing.T) {
+	tests := []struct {
+		status, diagnostic, want string
+	}{
+		{"5.1.1", "", BounceHard},
+		{"4.2.0", "", BounceSoft},
+		{"5.2.2", "", BounceSoft},
+		{"", "452 mailbox full", BounceSoft},
+		{"", "over quota", BounceSoft},
+		{"", "550 no such user", BounceHard},
+		{"", "smtp; 550", BounceHard},
+		{"", "no such user", BounceSoft},
+		{"", "", BounceSoft},
+		{"6.0.0", "", BounceSoft},
+		{"unknown", "550 no such user", BounceSoft},
+	}
+	for _, tt := range tests {
+		if got := classifyBounce(tt.status, tt.diagnostic); got != tt.want {
+			t.Errorf("classifyBounce(%q, %q) = %q, want %q", tt.status, tt.diagnostic, got, tt.want)
+		}
+	}
+}
+
+// mboxMessage: mbox entry with the closing blank line
+func mboxMessage(id string) string {
+	return "From sender@example.com Mon Jan  1 00:00:00 2024\n" +
+		"Message-ID: <" + id + ">\n" +
+		"\n" +
+		">From the body\n" +
+		"\n"
+}
+
+func TestReadMboxLeavesPartialMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bounces.mbox")
+	second := mboxMessage("two")
+	partial := second[:strings.Index(second, "\n\n")+1]
+	if err := os.WriteFile(path, []byte(mboxMessage("one")+partial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids, bodies []string
+	read := func(msg *mail.Message) error {
+		ids = append(ids, msg.Header.Get("Message-Id"))
+		body, err := io.ReadAll(msg.Body)
+		bodies = append(bodies, string(body))
+		return err
+	}
+
+	count, err := readMbox(path, read)
+	if err != nil || count != 1 {
+		t.Fatalf("readMbox = %d, %v; want 1 message", count, err)
+	}
+	if ids[0] != "<one>" || bodies[0] != "From the body\n\n" {
+		t.Errorf("message = %q %q", ids[0], bodies[0])
+	}
+
+	// The rest of the second message arrives
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(second[len(partial):])
+	f.Close()
+
+	count, err = readMbox(path, read)
+	if err != nil || count != 1 || ids[1] != "<two>" {
+		t.Fatalf("second readMbox = %d, %v, %q; want <two>", count, err, ids)
+	}
+	if count, _ := readMbox(path, read); count != 0 {
+		t.Errorf("third readMbox = %d, want 0", count)
+	}
+}
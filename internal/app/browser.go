@@ -0,0 +1,171 @@
+// browser.go: File browser of the Import screen
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// importExtensions are the file types the Import screen offers
+var importExtensions = []string{".txt", ".csv", ".tsv", ".vcf", ".json", ".ndjson", ".jsonl", ".db", ".sqlite", ".sqlite3"}
+
+// ImportEntry is a directory or importable file listed on the Import screen
+type ImportEntry struct {
+	Name  string
+	Path  string
+	IsDir bool
+	Size  int64
+}
+
+// isImportFile: The file has one of the import extensions, or ext when set
+func isImportFile(name, ext string) bool {
+	fileExt := strings.ToLower(filepath.Ext(name))
+	if ext != "" {
+		return fileExt == ext
+	}
+	for _, e := range importExtensions {
+		if fileExt == e {
+			return true
+		}
+	}
+	return false
+}
+
+// nextExtension: Cycles the extension filter: all, .txt, .csv, ..., all
+func nextExtension(ext string) string {
+	if ext == "" {
+		return importExtensions[0]
+	}
+	for i, e := range importExtensions {
+		if e == ext && i+1 < len(importExtensions) {
+			return importExtensions[i+1]
+		}
+	}
+	return ""
+}
+
+// ownFiles: Absolute paths of the files the app itself reads and writes,
+// which are never offered for import
+func (a *App) ownFiles() map[string]bool {
+	paths := []string{a.ConfigFile(), a.suppressionPath(), a.importHistoryPath(), a.variantStatePath()}
+	if a.cfg != nil {
+		paths = append(paths, a.cfg.Database.Path, a.cfg.Mail.Template)
+		for _, v := range a.cfg.Mail.Variants {
+			paths = append(paths, v.Template)
+		}
+	}
+	own := make(map[string]bool)
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			own[abs] = true
+		}
+	}
+	return own
+}
+
+// listImportDir: Subdirectories and importable files of dir whose name
+// contains filter, directories first. Hidden entries and the app's own
+// files are left out; ".." is listed unless dir is the root.
+func (a *App) listImportDir(dir, ext, filter string) ([]ImportEntry, error) {
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	own := a.ownFiles()
+	filter = strings.ToLower(filter)
+
+	var dirs, files []ImportEntry
+	for _, item := range items {
+		name := item.Name()
+		if strings.HasPrefix(name, ".") || !strings.Contains(strings.ToLower(name), filter) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			dirs = append(dirs, ImportEntry{Name: name, Path: path, IsDir: true})
+			continue
+		}
+		if !info.Mode().IsRegular() || !isImportFile(name, ext) {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil && own[abs] {
+			continue
+		}
+		files = append(files, ImportEntry{Name: name, Path: path, Size: info.Size()})
+	}
+	byName := func(entries []ImportEntry) {
+		sort.Slice(entries, func(i, j int) bool {
+			return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+		})
+	}
+	byName(dirs)
+	byName(files)
+
+	var entries []ImportEntry
+	if abs, err := filepath.Abs(dir); err == nil && filepath.Dir(abs) != abs {
+		entries = append(entries, ImportEntry{Name: "..", Path: filepath.Join(dir, ".."), IsDir: true})
+	}
+	entries = append(entries, dirs...)
+	return append(entries, files...), nil
+}
+
+// formatSize: 512 B, 1.4 KB, 23.0 MB
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, suffix := float64(size)/unit, "KB"
+	for _, s := range []string{"MB", "GB", "TB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, s
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// importBrowserContent: Renders the file list of the Import screen
+func importBrowserContent(dir string, entries []ImportEntry, selected int, ext, filter string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	types := strings.Join(importExtensions, " ")
+	if ext != "" {
+		types = ext
+	}
+	content := fmt.Sprintf("Select a file to import emails from %s\nShowing: %s", dir, types)
+	if filter != "" {
+		content += fmt.Sprintf(", names containing %q", filter)
+	}
+	content += "\n\n"
+
+	if len(entries) == 0 {
+		content += "  (no matching files)\n"
+	}
+	for i, e := range entries {
+		prefix := "  "
+		if i == selected {
+			prefix = "> "
+		}
+		if e.IsDir {
+			content += fmt.Sprintf("%s%-50s %10s\n", prefix, e.Name+"/", "")
+		} else {
+			content += fmt.Sprintf("%s%-50s %10s\n", prefix, e.Name, formatSize(e.Size))
+		}
+	}
+	content += "\nUp/Down to select, Enter to open a directory or preview the import, Backspace parent directory\n"
+	content += "f filter by name, Tab file type, u import history"
+	return content
+}
@@ -0,0 +1,540 @@
+// cli.go: Headless subcommands for scripts, cron jobs and CI
+
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the subcommands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	// exitIncomplete: the command ran, but some recipients failed or
+	// problems were found
+	exitIncomplete = 3
+)
+
+const cliUsage = `Usage: bulkmail [options] [command] [flags] [arguments]
+
+Without a command the terminal UI starts.
+
+Options:
+  --workdir DIR  Change to DIR before doing anything else
+  --config FILE  Config file (default $BULKMAIL_CONFIG, then config.yaml);
+                 relative paths in it are resolved against its directory
+  --db FILE      Database file, overriding database.path in the config
+
+Commands:
+  send [--limit N]                       Send pending messages until none are left
+  status                                 Print the statistics
+  import [--query SQL] [--dry-run] FILE  Import recipients from a file
+  export [--status LIST] [--output FILE] Write the records as CSV, or NDJSON with --json
+  requeue [--status LIST] [ADDRESS...]   Set FAILED (or LIST) records back to PENDING
+  validate                               Check the config, templates and database
+
+Every command takes --json for machine-readable output on stdout; logs go
+to stderr.
+
+Exit codes: 0 success, 1 error, 2 invalid arguments, 3 some recipients
+failed or problems were found.
+`
+
+// requeueStatuses are the statuses requeue accepts; the others record a
+// decision by the recipient or a check that must not be undone by a retry
+var requeueStatuses = map[string]bool{StatusFailed: true, StatusSending: true, StatusBounced: true}
+
+// cli is the state shared by the subcommands
+type cli struct {
+	app    *App
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+}
+
+var cliCommands = map[string]func(c *cli, args []string) int{
+	"send":     (*cli).send,
+	"status":   (*cli).status,
+	"import":   (*cli).importFile,
+	"export":   (*cli).export,
+	"requeue":  (*cli).requeue,
+	"validate": (*cli).validate,
+}
+
+// PrintUsage writes the usage of the options and commands to w
+func PrintUsage(w io.Writer) {
+	fmt.Fprint(w, cliUsage)
+}
+
+// RunCLI runs a subcommand without the TUI on an app that is not yet
+// initialized and returns its exit code
+func RunCLI(app *App, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, cliUsage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return exitOK
+	}
+	run, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+		return exitUsage
+	}
+	c := &cli{app: app, stdout: stdout, stderr: stderr}
+	return run(c, args[1:])
+}
+
+// flags: Flag set of a subcommand with the common --json flag
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.BoolVar(&c.json, "json", false, "machine-readable output")
+	return fs
+}
+
+// parse: Parses flags given before or after the arguments and checks the
+// number of arguments
+func (c *cli) parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, bool) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, false
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) < minArgs || (maxArgs >= 0 && len(positional) > maxArgs) {
+		fmt.Fprintf(c.stderr, "wrong number of arguments for %s\n\n%s", fs.Name(), cliUsage)
+		return nil, false
+	}
+	return positional, true
+}
+
+// init: Loads the config, templates and database
+func (c *cli) init() error {
+	return c.app.InitHeadless(c.stderr)
+}
+
+// fail: Reports an error on stderr, and as {"error": ...} on stdout with
+// --json, and returns code
+func (c *cli) fail(code int, err error) int {
+	fmt.Fprintf(c.stderr, "Error: %v\n", err)
+	if c.json {
+		c.print(map[string]string{"error": err.Error()}, "")
+	}
+	return code
+}
+
+// print: Writes v as JSON with --json, text otherwise
+func (c *cli) print(v any, text string) {
+	if c.json {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(v)
+		return
+	}
+	fmt.Fprint(c.stdout, text)
+}
+
+// statusList: "failed,bounced" -> {FAILED, BOUNCED}
+func statusList(list string) map[string]bool {
+	statuses := make(map[string]bool)
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+			statuses[s] = true
+		}
+	}
+	return statuses
+}
+
+// sendResult is the outcome of the send command
+type sendResult struct {
+	Sent    int    `json:"sent"`
+	Failed  int    `json:"failed"`
+	Pending int    `json:"pending"`
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// send: Runs the dispatcher until no pending recipient is left, --limit
+// messages were sent or the process is interrupted. An interrupted wait
+// puts the claimed recipient back to PENDING. Like the TUI it holds the
+// database lock, and it serves the tracking and unsubscribe links of the
+// messages while it runs.
+func (c *cli) send(args []string) int {
+	fs := c.flags("send")
+	limit := fs.Int("limit", 0, "send at most this many messages")
+	if _, ok := c.parse(fs, args, 0, 0); !ok {
+		return exitUsage
+	}
+	if err := c.init(); err != nil {
+		return c.fail(exitError, err)
+	}
+	a := c.app
+	defer a.Close()
+	if err := a.lockDatabase(); err != nil {
+		return c.fail(exitError, err)
+	}
+	if err := a.startTracking(); err != nil {
+		return c.fail(exitError, fmt.Errorf("failed to start tracking server: %v", err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := a.UpdateDataFile(a.cfg.Database.Path); err != nil {
+		return c.fail(exitError, err)
+	}
+	if count, err := ResetStuckSending(a.cfg.Database.Path, stuckSendingTimeout); err != nil {
+		return c.fail(exitError, err)
+	} else if count > 0 {
+		a.addLog(fmt.Sprintf("Reset %d stuck SENDING records to PENDING", count))
+	}
+
+	a.mu.Lock()
+	a.booted = true
+	a.mu.Unlock()
+
+	var result sendResult
+	held := false
+	for *limit <= 0 || result.Sent+result.Failed < *limit {
+		if ctx.Err() != nil {
+			result.Stopped = "interrupted"
+			break
+		}
+		// The complaint rate check stops the dispatcher
+		a.mu.Lock()
+		running := a.booted
+		a.mu.Unlock()
+		if !running {
+			result.Stopped = "complaint rate above complaints.max_rate_percent"
+			break
+		}
+
+		recipient, err := a.claimRecipient()
+		if errors.Is(err, ErrNoPendingRecipients) {
+			break
+		}
+		if errors.Is(err, errVariantHold) {
+			result.Stopped = "waiting for the A/B winner"
+			held = true
+			break
+		}
+		if err != nil {
+			return c.fail(exitError, err)
+		}
+
+		select {
+		case <-time.After(a.sendDelay()):
+		case <-ctx.Done():
+			if err := UpdateStatus(a.cfg.Database.Path, recipient.Email, StatusPending, ""); err != nil {
+				a.addLog(fmt.Sprintf("UpdateStatus error: %v", err))
+			}
+			continue
+		}
+		if err := a.sendTo(recipient); err != nil {
+			result.Failed++
+		} else {
+			result.Sent++
+		}
+	}
+
+	a.updateStats()
+	result.Pending = a.stats.Pending
+	text := fmt.Sprintf("Sent %d, failed %d, %d still pending\n", result.Sent, result.Failed, result.Pending)
+	if result.Stopped != "" {
+		text = fmt.Sprintf("Stopped (%s): ", result.Stopped) + text
+	}
+	c.print(result, text)
+
+	switch {
+	case held:
+		// Nothing went wrong, the rest goes out once a winner is picked
+		return exitIncomplete
+	case result.Stopped != "":
+		return exitError
+	case result.Failed > 0:
+		return exitIncomplete
+	}
+	return exitOK
+}
+
+// status: Prints the Stats screen, or the counts as JSON
+func (c *cli) status(args []string) int {
+	fs := c.flags("status")
+	if _, ok := c.parse(fs, args, 0, 0); !ok {
+		return exitUsage
+	}
+	if err := c.init(); err != nil {
+		return c.fail(exitError, err)
+	}
+	c.app.mu.Lock()
+	stats := c.app.stats
+	text := c.app.statsContent(stats)
+	c.app.mu.Unlock()
+	c.print(stats, text)
+	return exitOK
+}
+
+// importOutput is the outcome of the import command
+type importOutput struct {
+	ID             string `json:"id"`
+	Source         string `json:"source"`
+	DryRun         bool   `json:"dry_run"`
+	Found          int    `json:"found"`
+	Skipped        int    `json:"skipped"`
+	Added          int    `json:"added"`
+	FileDuplicates int    `json:"duplicates_in_file"`
+	DBDuplicates   int    `json:"already_in_database"`
+	Suppressed     int    `json:"suppressed"`
+	Invalid        int    `json:"invalid"`
+	PossibleTypos  int    `json:"possible_typos"`
+}
+
+// importFile: Imports a file like the watch folder does, or with --dry-run
+// only reports what the import would do
+func (c *cli) importFile(args []string) int {
+	fs := c.flags("import")
+	query := fs.String("query", "", "SELECT to run on a SQLite file (default import.sqlite_query)")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing")
+	files, ok := c.parse(fs, args, 1, 1)
+	if !ok {
+		return exitUsage
+	}
+	if err := c.init(); err != nil {
+		return c.fail(exitError, err)
+	}
+	a := c.app
+	if *query == "" {
+		*query = a.cfg.Import.SQLiteQuery
+	}
+
+	p := &ImportProgress{Source: files[0]}
+	plan, err := a.planFile(files[0], *query, p)
+	if err != nil {
+		return c.fail(exitError, err)
+	}
+	result := plan.Expected
+	if !*dryRun {
+		if result, err = a.CommitImport(plan, p); err != nil {
+			return c.fail(exitError, err)
+		}
+	}
+
+	out := importOutput{
+		ID: plan.ID, Source: plan.Source, DryRun: *dryRun, Found: plan.Found, Skipped: plan.Skipped,
+		Added: result.Added, FileDuplicates: result.FileDuplicates, DBDuplicates: result.DBDuplicates,
+		Suppressed: result.Suppressed, Invalid: result.Invalid, PossibleTypos: result.Warned,
+	}
+	verb := "added"
+	if *dryRun {
+		verb = "would be added"
+	}
+	c.print(out, fmt.Sprintf("Import %s from %s: %d addresses, %d %s, %d duplicates in file, %d already in database, %d suppressed, %d invalid, %d possible typos\n",
+		out.ID, out.Source, out.Found, out.Added, verb, out.FileDuplicates, out.DBDuplicates, out.Suppressed, out.Invalid, out.PossibleTypos))
+	return exitOK
+}
+
+// export: Writes records as CSV with a column per field, or as one JSON
+// object per line with --json
+func (c *cli) export(args []string) int {
+	fs := c.flags("export")
+	statuses := fs.String("status", "", "comma-separated statuses to export (default all)")
+	output := fs.String("output", "", "file to write (default stdout)")
+	if _, ok := c.parse(fs, args, 0, 0); !ok {
+		return exitUsage
+	}
+	if err := c.init(); err != nil {
+		return c.fail(exitError, err)
+	}
+	path := c.app.cfg.Database.Path
+	filter := statusList(*statuses)
+
+	w := c.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return c.fail(exitError, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	if c.json {
+		encoder := json.NewEncoder(w)
+		err = ExportRecords(path, filter, func(r RecordInfo) error {
+			object := map[string]string{"email": r.Email, "status": r.Status}
+			if r.Error != "" {
+				object["error"] = r.Error
+			}
+			if !r.Timestamp.IsZero() {
+				object["timestamp"] = r.Timestamp.Format(time.RFC3339)
+			}
+			for k, v := range r.Fields {
+				if object[k] == "" {
+					object[k] = v
+				}
+			}
+			return encoder.Encode(object)
+		})
+	} else {
+		err = exportCSV(w, path, filter)
+	}
+	if err != nil {
+		return c.fail(exitError, err)
+	}
+	return exitOK
+}
+
+// exportCSV: Reads the database twice, first for the field names that
+// make up the header
+func exportCSV(w io.Writer, path string, statuses map[string]bool) error {
+	seen := make(map[string]bool)
+	var fields []string
+	err := ExportRecords(path, statuses, func(r RecordInfo) error {
+		for k := range r.Fields {
+			if !seen[k] {
+				seen[k] = true
+				fields = append(fields, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(fields)
+
+	writer := csv.NewWriter(w)
+	writer.Write(append([]string{"email", "status", "timestamp", "error"}, fields...))
+	err = ExportRecords(path, statuses, func(r RecordInfo) error {
+		timestamp := ""
+		if !r.Timestamp.IsZero() {
+			timestamp = r.Timestamp.Format(time.RFC3339)
+		}
+		row := []string{r.Email, r.Status, timestamp, r.Error}
+		for _, k := range fields {
+			row = append(row, r.Fields[k])
+		}
+		return writer.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// requeue: Sets FAILED records, or those of --status, back to PENDING,
+// optionally only the given addresses
+func (c *cli) requeue(args []string) int {
+	fs := c.flags("requeue")
+	list := fs.String("status", StatusFailed, "comma-separated statuses to requeue: FAILED, SENDING, BOUNCED (soft bounces only)")
+	addresses, ok := c.parse(fs, args, 0, -1)
+	if !ok {
+		return exitUsage
+	}
+	statuses := statusList(*list)
+	for s := range statuses {
+		if !requeueStatuses[s] {
+			fmt.Fprintf(c.stderr, "cannot requeue %s records\n", s)
+			return exitUsage
+		}
+	}
+	if err := c.init(); err != nil {
+		return c.fail(exitError, err)
+	}
+
+	emails := make(map[string]bool)
+	for _, address := range addresses {
+		emails[strings.ToLower(strings.TrimSpace(address))] = true
+	}
+	count, err := RequeueRecords(c.app.cfg.Database.Path, statuses, emails)
+	if err != nil {
+		return c.fail(exitError, err)
+	}
+	c.print(map[string]int{"requeued": count}, fmt.Sprintf("Requeued %d records\n", count))
+	return exitOK
+}
+
+// validationResult is the outcome of the validate command
+type validationResult struct {
+	Valid    bool              `json:"valid"`
+	Error    string            `json:"error,omitempty"`
+	Problems []DatabaseProblem `json:"problems"`
+}
+
+// validate: Checks that the config and templates load and that every
+// pending address passes the offline checks. DNS is not queried.
+func (c *cli) validate(args []string) int {
+	fs := c.flags("validate")
+	if _, ok := c.parse(fs, args, 0, 0); !ok {
+		return exitUsage
+	}
+	result := validationResult{Problems: []DatabaseProblem{}}
+	if err := c.init(); err != nil {
+		result.Error = err.Error()
+		c.print(result, fmt.Sprintf("Invalid: %v\n", err))
+		return exitError
+	}
+	a := c.app
+
+	if a.cfg.SMTP.Host == "" {
+		result.Problems = append(result.Problems, DatabaseProblem{Problem: "smtp.host is not set"})
+	}
+	if reason := checkSyntax(a.cfg.SMTP.FromEmail); a.cfg.SMTP.FromEmail == "" || reason != "" {
+		result.Problems = append(result.Problems, DatabaseProblem{Email: a.cfg.SMTP.FromEmail, Problem: "smtp.from_email is not a valid address"})
+	}
+
+	validator := a.newValidator()
+	validator.Resolver = nil
+	problems, err := CheckDatabase(a.cfg.Database.Path, validator.Check)
+	if err != nil {
+		return c.fail(exitError, err)
+	}
+	result.Problems = append(result.Problems, problems...)
+	result.Valid = len(result.Problems) == 0
+
+	var text strings.Builder
+	for _, p := range result.Problems {
+		switch {
+		case p.Line > 0 && p.Email != "":
+			fmt.Fprintf(&text, "%s:%d: %s: %s\n", a.cfg.Database.Path, p.Line, p.Email, p.Problem)
+		case p.Line > 0:
+			fmt.Fprintf(&text, "%s:%d: %s\n", a.cfg.Database.Path, p.Line, p.Problem)
+		default:
+			fmt.Fprintf(&text, "%s: %s\n", c.app.ConfigFile(), p.Problem)
+		}
+	}
+	if result.Valid {
+		text.WriteString("Config, templates and database are valid\n")
+	} else {
+		fmt.Fprintf(&text, "%d problems found\n", len(result.Problems))
+	}
+	c.print(result, text.String())
+
+	if !result.Valid {
+		return exitIncomplete
+	}
+	return exitOK
+}
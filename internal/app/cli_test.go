@@ -0,0 +1,203 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCLITestData: Replaces the database of a CLI test app
+func writeCLITestData(t *testing.T, a *App, records ...string) string {
+	t.Helper()
+	path := filepath.Join(filepath.Dir(a.ConfigPath), "data.txt")
+	data := ""
+	for _, r := range records {
+		data += r + "\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runCLI: Runs a command on a fresh App for the config of a, as every
+// bulkmail invocation is a new process
+func runCLI(a *App, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := RunCLI(&App{ConfigPath: a.ConfigPath}, args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunCLIExitCodes(t *testing.T) {
+	a := newCLITestApp(t, "")
+	writeCLITestData(t, a,
+		"2026-01-01T00:00:00Z ; DONE ; sent@example.com",
+		"2026-01-01T00:00:00Z ; PENDING ; not-an-address",
+	)
+	empty := newCLITestApp(t, "")
+	missing := &App{ConfigPath: filepath.Join(t.TempDir(), "config.yaml")}
+
+	tests := []struct {
+		name string
+		app  *App
+		args []string
+		want int
+	}{
+		{"help", a, []string{"help"}, exitOK},
+		{"status", a, []string{"status"}, exitOK},
+		{"send with nothing pending", empty, []string{"send"}, exitOK},
+		{"no command", a, nil, exitUsage},
+		{"unknown command", a, []string{"frobnicate"}, exitUsage},
+		{"unknown flag", a, []string{"status", "--frobnicate"}, exitUsage},
+		{"extra argument", a, []string{"status", "now"}, exitUsage},
+		{"missing argument", a, []string{"import"}, exitUsage},
+		{"missing config", missing, []string{"status"}, exitError},
+		{"missing import file", a, []string{"import", "nope.txt"}, exitError},
+		{"problems found", a, []string{"validate"}, exitIncomplete},
+	}
+	for _, tt := range tests {
+		if code, _, stderr := runCLI(tt.app, tt.args...); code != tt.want {
+			t.Errorf("%s: exit %d, want %d\n%s", tt.name, code, tt.want, stderr)
+		}
+	}
+}
+
+func TestRunCLIFlagsAroundArguments(t *testing.T) {
+	for _, args := range [][]string{
+		{"requeue", "--status", "failed,bounced", "--json", "a@example.com", "b@example.com"},
+		{"requeue", "a@example.com", "--status", "failed,bounced", "b@example.com", "--json"},
+		{"requeue", "--json", "a@example.com", "b@example.com", "--status=FAILED,BOUNCED"},
+	} {
+		a := newCLITestApp(t, "")
+		writeCLITestData(t, a,
+			"2026-01-01T00:00:00Z ; FAILED ; a@example.com",
+			"2026-01-01T00:00:00Z ; BOUNCED ; b@example.com ;  ; bounce=soft",
+			"2026-01-01T00:00:00Z ; FAILED ; c@example.com",
+		)
+		code, stdout, stderr := runCLI(a, args...)
+		if code != exitOK {
+			t.Fatalf("%q: exit %d\n%s", args, code, stderr)
+		}
+		var out map[string]int
+		if err := json.Unmarshal([]byte(stdout), &out); err != nil || out["requeued"] != 2 {
+			t.Errorf("%q: %s, %v; want 2 requeued", args, stdout, err)
+		}
+	}
+}
+
+func TestRunCLIJSON(t *testing.T) {
+	a := newCLITestApp(t, "")
+	writeCLITestData(t, a,
+		"2026-01-01T00:00:00Z ; PENDING ; ann@example.com ;  ; name=Ann",
+		"2026-01-01T00:00:00Z ; FAILED ; bob@example.com ; 550 rejected",
+	)
+
+	code, stdout, _ := runCLI(a, "status", "--json")
+	var stats map[string]any
+	if err := json.Unmarshal([]byte(stdout), &stats); code != exitOK || err != nil {
+		t.Fatalf("status --json: exit %d, %v\n%s", code, err, stdout)
+	}
+	if stats["pending"] != 1.0 || stats["failed"] != 1.0 {
+		t.Errorf("status --json = %v", stats)
+	}
+
+	code, stdout, _ = runCLI(a, "export", "--json", "--status", "pending")
+	var records []map[string]string
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		var record map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("export --json line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if code != exitOK || len(records) != 1 || records[0]["email"] != "ann@example.com" || records[0]["name"] != "Ann" {
+		t.Errorf("export --json: exit %d, %v", code, records)
+	}
+
+	code, stdout, _ = runCLI(a, "import", "--json", "nope.txt")
+	var failure map[string]string
+	if err := json.Unmarshal([]byte(stdout), &failure); code != exitError || err != nil || failure["error"] == "" {
+		t.Errorf("failed import --json: exit %d, %q", code, stdout)
+	}
+
+	code, stdout, _ = runCLI(a, "validate", "--json")
+	var result validationResult
+	if err := json.Unmarshal([]byte(stdout), &result); code != exitOK || err != nil || !result.Valid {
+		t.Errorf("validate --json: exit %d, %q", code, stdout)
+	}
+}
+
+func TestRunCLIRequeueRefusesStatuses(t *testing.T) {
+	a := newCLITestApp(t, "")
+	records := []string{
+		"2026-01-01T00:00:00Z ; DONE ; done@example.com",
+		"2026-01-01T00:00:00Z ; UNSUBSCRIBED ; gone@example.com",
+		"2026-01-01T00:00:00Z ; COMPLAINED ; angry@example.com",
+		"2026-01-01T00:00:00Z ; BOUNCED ; hard@example.com ;  ; bounce=hard",
+	}
+	path := writeCLITestData(t, a, records...)
+
+	for _, status := range []string{"DONE", "unsubscribed", "COMPLAINED", "SUPPRESSED", "INVALID", "failed,PENDING"} {
+		code, _, stderr := runCLI(a, "requeue", "--status", status)
+		if code != exitUsage || !strings.Contains(stderr, "cannot requeue") {
+			t.Errorf("requeue --status %s: exit %d, %q; want exit %d", status, code, stderr, exitUsage)
+		}
+	}
+	// Hard bounces are never requeued, even when BOUNCED is asked for
+	if code, stdout, _ := runCLI(a, "requeue", "--status", "bounced"); code != exitOK || stdout != "Requeued 0 records\n" {
+		t.Errorf("requeue --status bounced: exit %d, %q", code, stdout)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(records, "\n") + "\n"; string(data) != want {
+		t.Errorf("database changed:\n%s", data)
+	}
+}
+
+func TestSendHoldsForVariantWinner(t *testing.T) {
+	a := newCLITestApp(t, `
+tracking:
+  listen: 127.0.0.1:0
+  base_url: https://track.example.com
+  secret: 0123456789abcdef
+  opens: true
+`)
+	config, err := os.ReadFile(a.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = bytes.Replace(config, []byte("  template: mail.html\n"), []byte(`  template: mail.html
+  variant_sample_size: 1
+  variants:
+    - name: a
+      weight: 1
+    - name: b
+      weight: 1
+`), 1)
+	if err := os.WriteFile(a.ConfigPath, config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(a.ConfigPath)
+	if err := os.WriteFile(filepath.Join(dir, "variants.txt"), []byte(time.Now().UTC().Format(time.RFC3339)+" ; a,b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeCLITestData(t, a, "2026-01-01T00:00:00Z ; PENDING ; ann@example.com")
+
+	code, stdout, stderr := runCLI(a, "send", "--json")
+	var result sendResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("send --json: %v\n%s\n%s", err, stdout, stderr)
+	}
+	if code != exitIncomplete || result.Stopped == "" || result.Pending != 1 {
+		t.Errorf("send = %d, %+v; want exit %d with the recipient held back", code, result, exitIncomplete)
+	}
+}
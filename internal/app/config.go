@@ -2,10 +2,20 @@ package app
 
 import (
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
 
+const defaultConfigPath = "config.yaml"
+
+// configEnv names the environment variable with the config path used when
+// no --config flag is given
+const configEnv = "BULKMAIL_CONFIG"
+
+// LoadConfig reads a config file. Relative paths in it are resolved against
+// the directory of the file, so a campaign directory can be used from
+// anywhere.
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -15,9 +25,61 @@ func LoadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	cfg.dir = filepath.Dir(path)
+	for _, p := range []*string{
+		&cfg.Mail.Template, &cfg.Database.Path, &cfg.Suppression.Path,
+		&cfg.Import.HistoryPath, &cfg.Import.WatchDir, &cfg.Mail.VariantStatePath,
+		&cfg.Bounces.Maildir, &cfg.Bounces.Mbox,
+		&cfg.Complaints.Maildir, &cfg.Complaints.Mbox,
+	} {
+		*p = cfg.resolve(*p)
+	}
+	for i := range cfg.Mail.Variants {
+		cfg.Mail.Variants[i].Template = cfg.resolve(cfg.Mail.Variants[i].Template)
+	}
 	return &cfg, nil
 }
 
+// resolve: Joins a relative path to the directory of the config file
+func (c *Config) resolve(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.dir, path)
+}
+
+// ConfigFile returns the config file given by --config, BULKMAIL_CONFIG or
+// the default
+func (a *App) ConfigFile() string {
+	if a.ConfigPath != "" {
+		return a.ConfigPath
+	}
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	return defaultConfigPath
+}
+
+// RequiredFiles returns the config file and the database and template it
+// names, with the database given by --db taking precedence. Without a
+// config file they are the sample's data.txt and mail.html next to it.
+func (a *App) RequiredFiles() ([]string, error) {
+	config := a.ConfigFile()
+	dir := filepath.Dir(config)
+	files := []string{config, filepath.Join(dir, "data.txt"), filepath.Join(dir, "mail.html")}
+	if _, err := os.Stat(config); err == nil {
+		cfg, err := LoadConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		files[1], files[2] = cfg.Database.Path, cfg.Mail.Template
+	}
+	if a.DBPath != "" {
+		files[1] = a.DBPath
+	}
+	return files, nil
+}
+
 func InitDB(path string) error {
 	// Verify database file exists
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigResolvesPaths(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(t.TempDir(), "shared-suppression.txt")
+	config := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(config, []byte(`
+mail:
+  template: templates/mail.html
+  variants:
+    - name: b
+      template: templates/mail-b.html
+    - name: a
+database:
+  path: data.txt
+suppression:
+  path: `+abs+`
+import:
+  history_path: ../imports.txt
+  watch_dir: drop
+bounces:
+  maildir: mail/bounces
+complaints:
+  mbox: mail/complaints.mbox
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ name, got, want string }{
+		{"mail.template", cfg.Mail.Template, filepath.Join(dir, "templates", "mail.html")},
+		{"variant template", cfg.Mail.Variants[0].Template, filepath.Join(dir, "templates", "mail-b.html")},
+		{"variant without template", cfg.Mail.Variants[1].Template, ""},
+		{"database.path", cfg.Database.Path, filepath.Join(dir, "data.txt")},
+		{"absolute suppression.path", cfg.Suppression.Path, abs},
+		{"import.history_path", cfg.Import.HistoryPath, filepath.Join(filepath.Dir(dir), "imports.txt")},
+		{"import.watch_dir", cfg.Import.WatchDir, filepath.Join(dir, "drop")},
+		{"bounces.maildir", cfg.Bounces.Maildir, filepath.Join(dir, "mail", "bounces")},
+		{"unset bounces.mbox", cfg.Bounces.Mbox, ""},
+		{"complaints.mbox", cfg.Complaints.Mbox, filepath.Join(dir, "mail", "complaints.mbox")},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// Defaults of the side files live next to the config as well
+	a := &App{cfg: cfg}
+	if got, want := a.variantStatePath(), filepath.Join(dir, "variants.txt"); got != want {
+		t.Errorf("default variant state path %q, want %q", got, want)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	t.Setenv(configEnv, "")
+	if got := (&App{}).ConfigFile(); got != defaultConfigPath {
+		t.Errorf("without flag or env: %q, want %q", got, defaultConfigPath)
+	}
+	t.Setenv(configEnv, "/campaigns/env/config.yaml")
+	if got := (&App{}).ConfigFile(); got != "/campaigns/env/config.yaml" {
+		t.Errorf("with %s: %q", configEnv, got)
+	}
+	if got := (&App{ConfigPath: "/campaigns/flag/config.yaml"}).ConfigFile(); got != "/campaigns/flag/config.yaml" {
+		t.Errorf("--config does not win over %s: %q", configEnv, got)
+	}
+}
+
+// cliPending: Pending count reported by status --json
+func cliPending(t *testing.T, a *App) int {
+	t.Helper()
+	code, stdout, stderr := runCLI(a, "status", "--json")
+	var stats Stats
+	if err := json.Unmarshal([]byte(stdout), &stats); code != exitOK || err != nil {
+		t.Fatalf("status: exit %d, %v\n%s", code, err, stderr)
+	}
+	return stats.Pending
+}
+
+func TestConfigFlagAndEnv(t *testing.T) {
+	fromEnv := newCLITestApp(t, "")
+	writeCLITestData(t, fromEnv, "2026-01-01T00:00:00Z ; PENDING ; env@example.com")
+	fromFlag := newCLITestApp(t, "")
+	writeCLITestData(t, fromFlag,
+		"2026-01-01T00:00:00Z ; PENDING ; flag1@example.com",
+		"2026-01-01T00:00:00Z ; PENDING ; flag2@example.com",
+	)
+
+	t.Setenv(configEnv, fromEnv.ConfigPath)
+	if got := cliPending(t, &App{}); got != 1 {
+		t.Errorf("%s: %d pending, want the 1 of its database", configEnv, got)
+	}
+	if got := cliPending(t, fromFlag); got != 2 {
+		t.Errorf("--config with %s set: %d pending, want the 2 of its database", configEnv, got)
+	}
+}
+
+func TestDBOverride(t *testing.T) {
+	a := newCLITestApp(t, "")
+	writeCLITestData(t, a, "2026-01-01T00:00:00Z ; PENDING ; config@example.com")
+	other := filepath.Join(t.TempDir(), "other.txt")
+	if err := os.WriteFile(other, []byte(
+		"2026-01-01T00:00:00Z ; PENDING ; db1@example.com\n"+
+			"2026-01-01T00:00:00Z ; PENDING ; db2@example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	withDB := &App{ConfigPath: a.ConfigPath, DBPath: other}
+	if err := withDB.load(); err != nil {
+		t.Fatal(err)
+	}
+	if withDB.cfg.Database.Path != other {
+		t.Errorf("database %q with --db, want %q", withDB.cfg.Database.Path, other)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI(&App{ConfigPath: a.ConfigPath, DBPath: other}, []string{"status", "--json"}, &stdout, &stderr)
+	var stats Stats
+	if err := json.Unmarshal(stdout.Bytes(), &stats); code != exitOK || err != nil || stats.Pending != 2 {
+		t.Errorf("status with --db: exit %d, %+v, %v", code, stats, err)
+	}
+}
+
+func TestRequiredFiles(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	a := &App{ConfigPath: config}
+
+	files, err := a.RequiredFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{config, filepath.Join(dir, "data.txt"), filepath.Join(dir, "mail.html")}
+	if strings.Join(files, "|") != strings.Join(want, "|") {
+		t.Errorf("without a config: %q, want the sample's %q", files, want)
+	}
+
+	if err := os.WriteFile(config, []byte("mail:\n  template: templates/news.html\ndatabase:\n  path: lists/news.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err = a.RequiredFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{config, filepath.Join(dir, "lists", "news.txt"), filepath.Join(dir, "templates", "news.html")}
+	if strings.Join(files, "|") != strings.Join(want, "|") {
+		t.Errorf("with a config: %q, want its paths %q", files, want)
+	}
+
+	a.DBPath = "other.txt"
+	if files, err = a.RequiredFiles(); err != nil || files[1] != "other.txt" {
+		t.Errorf("with --db: %q, %v", files, err)
+	}
+
+	if err := os.WriteFile(config, []byte("mail: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.RequiredFiles(); err == nil {
+		t.Error("broken config accepted")
+	}
+}
@@ -0,0 +1,246 @@
+// contacts.go: Reads recipients with display names from RFC 5322 address
+// lists and vCard files
+
+package app
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	// "Name" <addr> or Name <addr> inside free text
+	namedAddressRegex = regexp.MustCompile(`(?:"((?:[^"\\]|\\.)*)"|([^,;:<>"\t]+?))\s*<\s*([a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,})\s*>`)
+	// To:, Cc: etc. in front of a pasted header line
+	addressHeaderRegex = regexp.MustCompile(`(?i)^\s*(to|cc|bcc|from|reply-to)\s*:\s*`)
+
+	wordDecoder   = &mime.WordDecoder{}
+	addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+)
+
+// parseAddressLine: Extracts recipients from one line of text. Lines that are
+// a valid RFC 5322 address list keep their (decoded) display names; anything
+// else is scanned for "Name <addr>" pairs and bare addresses.
+func parseAddressLine(line string) []ImportRow {
+	line = strings.TrimSpace(addressHeaderRegex.ReplaceAllString(line, ""))
+	if line == "" || !strings.Contains(line, "@") {
+		return nil
+	}
+
+	if list, err := addressParser.ParseList(line); err == nil {
+		rows := make([]ImportRow, 0, len(list))
+		for _, addr := range list {
+			rows = append(rows, newContactRow(addr.Address, addr.Name, ""))
+		}
+		return rows
+	}
+
+	var rows []ImportRow
+	named := make(map[string]bool)
+	for _, m := range namedAddressRegex.FindAllStringSubmatch(line, -1) {
+		name := m[2]
+		if m[1] != "" {
+			name = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[1])
+		}
+		rows = append(rows, newContactRow(m[3], decodeWords(name), ""))
+		named[m[3]] = true
+	}
+	for _, email := range emailRegex.FindAllString(line, -1) {
+		if !named[email] {
+			rows = append(rows, newContactRow(email, "", ""))
+		}
+	}
+	return rows
+}
+
+// decodeWords: Decodes RFC 2047 encoded words, keeping the input on error
+func decodeWords(s string) string {
+	if decoded, err := wordDecoder.DecodeHeader(s); err == nil {
+		s = decoded
+	}
+	return strings.TrimSpace(s)
+}
+
+// newContactRow: Import row with optional name and org fields
+func newContactRow(email, name, org string) ImportRow {
+	row := ImportRow{Email: email, Fields: make(map[string]string)}
+	// A display name that is just the address adds nothing
+	if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, email) {
+		row.Fields[FieldName] = name
+	}
+	if org = strings.TrimSpace(org); org != "" {
+		row.Fields[FieldOrg] = org
+	}
+	return row
+}
+
+// vcardProperty is one unfolded content line of a vCard
+type vcardProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// readVCards: Reads FN, N, EMAIL and ORG from a vCard (2.1, 3.0, 4.0) file.
+// Each card yields one row for its preferred (or first) address.
+func readVCards(r io.Reader, fn func(ImportRow) error) error {
+	var card []vcardProperty
+
+	return unfoldVCard(r, func(line string) error {
+		prop, ok := parseVCardLine(line)
+		if !ok {
+			return nil
+		}
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VCARD"):
+			card = nil
+		case prop.name == "END" && strings.EqualFold(prop.value, "VCARD"):
+			row, ok := vcardRow(card)
+			card = nil
+			if ok {
+				return fn(row)
+			}
+		default:
+			card = append(card, prop)
+		}
+		return nil
+	})
+}
+
+// unfoldVCard: Joins folded lines (continuations start with a space or tab,
+// or follow a quoted-printable soft line break)
+func unfoldVCard(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	current := ""
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"):
+			current += line[1:]
+			continue
+		case strings.HasSuffix(current, "=") && strings.Contains(strings.ToUpper(current), "QUOTED-PRINTABLE"):
+			current += "\n" + line
+			continue
+		}
+		if current != "" {
+			if err := fn(current); err != nil {
+				return err
+			}
+		}
+		current = line
+	}
+	if current != "" {
+		if err := fn(current); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseVCardLine: "item1.EMAIL;TYPE=work,pref:bob@example.com"
+func parseVCardLine(line string) (vcardProperty, bool) {
+	head, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return vcardProperty{}, false
+	}
+	parts := strings.Split(head, ";")
+	name := strings.ToUpper(parts[0])
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	prop := vcardProperty{name: name, params: make(map[string]string), value: value}
+	for _, p := range parts[1:] {
+		key, val, hasValue := strings.Cut(p, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if !hasValue {
+			// vCard 2.1 bare types: EMAIL;INTERNET;PREF
+			val, key = key, "TYPE"
+		}
+		if prev := prop.params[key]; prev != "" {
+			val = prev + "," + val
+		}
+		prop.params[key] = strings.ToUpper(strings.Trim(val, `"`))
+	}
+
+	if prop.params["ENCODING"] == "QUOTED-PRINTABLE" {
+		data, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+		if err == nil {
+			prop.value = string(data)
+		}
+	}
+	return prop, true
+}
+
+// vcardRow: Builds the import row of one card
+func vcardRow(card []vcardProperty) (ImportRow, bool) {
+	var fn, n, org, email string
+	emailPref := false
+	for _, p := range card {
+		switch p.name {
+		case "FN":
+			fn = vcardText(p.value)
+		case "N":
+			// Family;Given;Additional;Prefix;Suffix
+			parts := splitVCardValue(p.value)
+			var names []string
+			for _, i := range []int{3, 1, 2, 0, 4} {
+				if i < len(parts) && parts[i] != "" {
+					names = append(names, parts[i])
+				}
+			}
+			n = strings.Join(names, " ")
+		case "ORG":
+			// Organisation;Unit;...
+			if parts := splitVCardValue(p.value); len(parts) > 0 {
+				org = parts[0]
+			}
+		case "EMAIL":
+			value := cleanAddress(vcardText(p.value))
+			if !emailValueRegex.MatchString(value) {
+				continue
+			}
+			pref := strings.Contains(p.params["TYPE"], "PREF") || p.params["PREF"] == "1"
+			if email == "" || (pref && !emailPref) {
+				email, emailPref = value, pref
+			}
+		}
+	}
+	if email == "" {
+		return ImportRow{}, false
+	}
+	return newContactRow(email, firstNonEmpty(fn, n), org), true
+}
+
+// splitVCardValue: Splits a structured value on unescaped ";"
+func splitVCardValue(value string) []string {
+	var parts []string
+	var current strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			current.WriteString(value[i : i+2])
+			i++
+		case value[i] == ';':
+			parts = append(parts, vcardText(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(value[i])
+		}
+	}
+	return append(parts, vcardText(current.String()))
+}
+
+// vcardText: Unescapes a vCard text value
+func vcardText(value string) string {
+	replacer := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return strings.TrimSpace(replacer.Replace(value))
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadVCards(t *testing.T) {
+	data := "BEGIN:VCARD\r\n" +
+		"VERSION:2.1\r\n" +
+		"N:Yılmaz;Ayşe;;;\r\n" +
+		"FN;ENCODING=QUOTED-PRINTABLE;CHARSET=UTF-8:Ay=C5=9Fe Y=C4=B1lmaz\r\n" +
+		"EMAIL;INTERNET:ayse@example.com\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Smith;Bob;;Dr.;\r\n" +
+		"ORG:ACME\\, Inc.;Sales\r\n" +
+		"EMAIL;TYPE=home:bob@home.example\r\n" +
+		"item1.EMAIL;TYPE=work,pref:bob@acme.exa\r\n" +
+		" mple\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"FN:No Address\r\n" +
+		"EMAIL:not-an-address\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"FN:carol@example.com\r\n" +
+		"EMAIL;PREF=1:<carol@example.com>\r\n" +
+		"END:VCARD\r\n"
+
+	var rows []ImportRow
+	err := readVCards(strings.NewReader(data), func(row ImportRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		email, name, org string
+	}{
+		{"ayse@example.com", "Ayşe Yılmaz", ""},
+		{"bob@acme.example", "Dr. Bob Smith", "ACME, Inc."},
+		{"carol@example.com", "", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows %+v, want %d", rows, len(want))
+	}
+	for i, w := range want {
+		r := rows[i]
+		if r.Email != w.email || r.Fields[FieldName] != w.name || r.Fields[FieldOrg] != w.org {
+			t.Errorf("row %d = %q %v, want %q name %q org %q", i, r.Email, r.Fields, w.email, w.name, w.org)
+		}
+	}
+}
+
+func TestParseAddressLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string // email=name pairs
+	}{
+		{"To: \"Smith, Bob\" <bob@example.com>, carol@example.com", []string{"bob@example.com=Smith, Bob", "carol@example.com="}},
+		{"=?UTF-8?B?QXnFn2U=?= <ayse@example.com>", []string{"ayse@example.com=Ayşe"}},
+		{"Contact Dave <dave@example.com> or sales@example.com; thanks!", []string{"dave@example.com=Contact Dave", "sales@example.com="}},
+		{"no addresses here", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, row := range parseAddressLine(tt.line) {
+			got = append(got, row.Email+"="+row.Fields[FieldName])
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("parseAddressLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
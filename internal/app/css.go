@@ -0,0 +1,290 @@
+// css.go: Inlines <style> rules into style attributes for mail clients
+// that strip style blocks
+
+package app
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	styleBlockRegex  = regexp.MustCompile(`(?is)<style[^>]*>(.*?)</style>`)
+	cssCommentRegex  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	startTagRegex    = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)((?:\s[^<>]*?)?)(/?)>`)
+	attrRegex        = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	styleAttrRegex   = regexp.MustCompile(`(?i)\sstyle\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	simpleSelectorRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*|\*)?((?:[.#][-_a-zA-Z0-9]+)*)$`)
+	importantRegex   = regexp.MustCompile(`(?i)!\s*important\s*$`)
+)
+
+// cssRule is a single inlinable rule with a simple selector
+type cssRule struct {
+	tag         string
+	id          string
+	classes     []string
+	specificity int
+	order       int
+	decls       []cssDecl
+}
+
+type cssDecl struct {
+	property  string
+	value     string
+	important bool
+}
+
+// inlineCSS: Moves rules from <style> blocks onto matching elements.
+// Only simple selectors (tag, .class, #id and combinations like p.note) are
+// inlined; media queries, other at-rules and complex selectors are kept in a
+// single retained <style> block.
+func inlineCSS(html string) string {
+	blocks := styleBlockRegex.FindAllStringSubmatchIndex(html, -1)
+	if len(blocks) == 0 {
+		return html
+	}
+
+	var rules []cssRule
+	var retained []string
+	for _, b := range blocks {
+		r, keep := parseCSS(html[b[2]:b[3]], len(rules))
+		rules = append(rules, r...)
+		retained = append(retained, keep...)
+	}
+
+	// Replace the first style block with the retained rules and drop the rest
+	var out strings.Builder
+	last := 0
+	for i, b := range blocks {
+		out.WriteString(html[last:b[0]])
+		if i == 0 && len(retained) > 0 {
+			out.WriteString("<style type=\"text/css\">\n" + strings.Join(retained, "\n") + "\n</style>")
+		}
+		last = b[1]
+	}
+	out.WriteString(html[last:])
+
+	if len(rules) == 0 {
+		return out.String()
+	}
+	return applyCSSRules(out.String(), rules)
+}
+
+// parseCSS: Splits a stylesheet into inlinable rules and retained text
+func parseCSS(css string, orderOffset int) ([]cssRule, []string) {
+	css = cssCommentRegex.ReplaceAllString(css, "")
+
+	var rules []cssRule
+	var retained []string
+	for i := 0; i < len(css); {
+		open := strings.IndexByte(css[i:], '{')
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(css[i : i+open])
+		end := matchingBrace(css, i+open)
+		if end < 0 {
+			// Unbalanced braces, keep the remainder untouched
+			retained = append(retained, strings.TrimSpace(css[i:]))
+			break
+		}
+		body := css[i+open+1 : end]
+		i = end + 1
+
+		if strings.HasPrefix(prelude, "@") {
+			retained = append(retained, prelude+" {"+body+"}")
+			continue
+		}
+
+		decls := parseDecls(body)
+		var complexSelectors []string
+		for _, sel := range strings.Split(prelude, ",") {
+			sel = strings.TrimSpace(sel)
+			rule, ok := parseSimpleSelector(sel)
+			if !ok {
+				complexSelectors = append(complexSelectors, sel)
+				continue
+			}
+			rule.order = orderOffset + len(rules)
+			rule.decls = decls
+			rules = append(rules, rule)
+		}
+		if len(complexSelectors) > 0 {
+			retained = append(retained, strings.Join(complexSelectors, ", ")+" {"+body+"}")
+		}
+	}
+	return rules, retained
+}
+
+// matchingBrace: Returns the index of the brace closing the one at open
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseDecls(body string) []cssDecl {
+	var decls []cssDecl
+	for _, part := range strings.Split(body, ";") {
+		property, value, ok := strings.Cut(part, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		important := false
+		if m := importantRegex.FindStringIndex(value); m != nil {
+			value = strings.TrimSpace(value[:m[0]])
+			important = true
+		}
+		if property == "" || value == "" {
+			continue
+		}
+		decls = append(decls, cssDecl{property: property, value: value, important: important})
+	}
+	return decls
+}
+
+func parseSimpleSelector(sel string) (cssRule, bool) {
+	m := simpleSelectorRe.FindStringSubmatch(sel)
+	if m == nil || sel == "" {
+		return cssRule{}, false
+	}
+
+	rule := cssRule{tag: strings.ToLower(m[1])}
+	if rule.tag == "*" {
+		rule.tag = ""
+	}
+	if rule.tag != "" {
+		rule.specificity++
+	}
+
+	rest := m[2]
+	for rest != "" {
+		kind := rest[0]
+		next := strings.IndexAny(rest[1:], ".#")
+		name := rest[1:]
+		if next >= 0 {
+			name = rest[1 : next+1]
+			rest = rest[next+1:]
+		} else {
+			rest = ""
+		}
+		if kind == '#' {
+			rule.id = name
+			rule.specificity += 100
+		} else {
+			rule.classes = append(rule.classes, name)
+			rule.specificity += 10
+		}
+	}
+	return rule, true
+}
+
+// applyCSSRules: Writes matching declarations into each element's style
+// attribute. Existing inline styles win over stylesheet rules, unless the
+// stylesheet declaration is !important.
+func applyCSSRules(html string, rules []cssRule) string {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	return startTagRegex.ReplaceAllStringFunc(html, func(tag string) string {
+		m := startTagRegex.FindStringSubmatch(tag)
+		name := strings.ToLower(m[1])
+		switch name {
+		case "html", "head", "meta", "title", "style", "script", "link", "base":
+			return tag
+		}
+
+		attrs := m[2]
+		var id string
+		var classes []string
+		var inline string
+		for _, a := range attrRegex.FindAllStringSubmatch(attrs, -1) {
+			value := strings.Trim(a[2], `"'`)
+			switch strings.ToLower(a[1]) {
+			case "id":
+				id = value
+			case "class":
+				classes = strings.Fields(value)
+			case "style":
+				inline = value
+			}
+		}
+
+		var decls []cssDecl
+		for _, rule := range rules {
+			if rule.matches(name, id, classes) {
+				decls = append(decls, rule.decls...)
+			}
+		}
+		if len(decls) == 0 {
+			return tag
+		}
+		decls = append(decls, parseDecls(inline)...)
+
+		style := mergeDecls(decls)
+		attrs = styleAttrRegex.ReplaceAllString(attrs, "")
+		return "<" + m[1] + attrs + ` style="` + style + `"` + m[3] + ">"
+	})
+}
+
+func (r cssRule) matches(tag, id string, classes []string) bool {
+	if r.tag != "" && r.tag != tag {
+		return false
+	}
+	if r.id != "" && r.id != id {
+		return false
+	}
+	for _, want := range r.classes {
+		found := false
+		for _, c := range classes {
+			if c == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDecls: Later declarations override earlier ones, keeping first
+// position, except that a normal declaration never overrides an !important
+// one. The !important marker itself is not written to the style attribute.
+func mergeDecls(decls []cssDecl) string {
+	var order []string
+	values := make(map[string]cssDecl)
+	for _, d := range decls {
+		cur, ok := values[d.property]
+		if !ok {
+			order = append(order, d.property)
+		} else if cur.important && !d.important {
+			continue
+		}
+		values[d.property] = d
+	}
+
+	parts := make([]string, 0, len(order))
+	for _, p := range order {
+		parts = append(parts, p+": "+strings.ReplaceAll(values[p].value, `"`, "'"))
+	}
+	return strings.Join(parts, "; ")
+}
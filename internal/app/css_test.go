@@ -0,0 +1,113 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []string
+		not  []string
+	}{
+		{
+			name: "specificity beats order",
+			html: `<style>#main { color: red } p.note { color: green } .note { color: blue } p { color: black }</style><p id="main" class="note">x</p><p class="note">y</p>`,
+			want: []string{`<p id="main" class="note" style="color: red">`, `<p class="note" style="color: green">`},
+		},
+		{
+			name: "later rule wins at equal specificity",
+			html: `<style>.a { color: red } .b { color: blue }</style><p class="a b">x</p>`,
+			want: []string{`<p class="a b" style="color: blue">`},
+		},
+		{
+			name: "inline style beats stylesheet",
+			html: `<style>p { color: red; margin: 0 }</style><p style="color: blue">x</p>`,
+			want: []string{`<p style="color: blue; margin: 0">`},
+		},
+		{
+			name: "important beats inline style",
+			html: `<style>p { color: red !important; margin: 0 }</style><p style="color: blue; margin: 4px">x</p>`,
+			want: []string{`<p style="color: red; margin: 4px">`},
+			not:  []string{"!important"},
+		},
+		{
+			name: "important beats specificity",
+			html: `<style>p { color: red ! IMPORTANT } #main { color: blue }</style><p id="main">x</p>`,
+			want: []string{`<p id="main" style="color: red">`},
+		},
+		{
+			name: "inline important beats stylesheet important",
+			html: `<style>p { color: red !important }</style><p style="color: blue !important">x</p>`,
+			want: []string{`<p style="color: blue">`},
+		},
+		{
+			name: "media queries are kept",
+			html: `<style>p { color: red } @media (max-width: 600px) { p { color: blue } }</style><p>x</p>`,
+			want: []string{"<style type=\"text/css\">\n@media (max-width: 600px) { p { color: blue } }\n</style>", `<p style="color: red">`},
+		},
+		{
+			name: "complex selectors are left alone",
+			html: `<style>div p, a:hover { color: red } a { color: blue }</style><div><p>x</p><a href="#">y</a></div>`,
+			want: []string{"div p, a:hover { color: red }", `<p>x</p>`, `<div>`, `<a href="#" style="color: blue">`},
+		},
+		{
+			name: "self-closing tags stay closed",
+			html: `<style>img { border: 0 } br { clear: both }</style><img src="a.png" /><br/>`,
+			want: []string{`style="border: 0"/>`, `<br style="clear: both"/>`},
+		},
+		{
+			name: "no style block",
+			html: `<p style="color: red">x</p>`,
+			want: []string{`<p style="color: red">x</p>`},
+		},
+		{
+			name: "all rules inlined drops the block",
+			html: `<head><style>p { color: red }</style></head><p>x</p>`,
+			want: []string{`<head></head>`},
+			not:  []string{"<style"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := inlineCSS(tt.html)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q in\n%s", want, got)
+				}
+			}
+			for _, not := range tt.not {
+				if strings.Contains(got, not) {
+					t.Errorf("unexpected %q in\n%s", not, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSimpleSelector(t *testing.T) {
+	tests := []struct {
+		sel         string
+		ok          bool
+		specificity int
+	}{
+		{"p", true, 1},
+		{".note", true, 10},
+		{"p.note.big", true, 21},
+		{"#main", true, 100},
+		{"td#main.cell", true, 111},
+		{"*", true, 0},
+		{"div p", false, 0},
+		{"a:hover", false, 0},
+		{"ul > li", false, 0},
+		{"[href]", false, 0},
+	}
+	for _, tt := range tests {
+		rule, ok := parseSimpleSelector(tt.sel)
+		if ok != tt.ok || rule.specificity != tt.specificity {
+			t.Errorf("parseSimpleSelector(%q) = %d, %v; want %d, %v", tt.sel, rule.specificity, ok, tt.specificity, tt.ok)
+		}
+	}
+}
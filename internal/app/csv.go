@@ -0,0 +1,453 @@
+// csv.go: CSV/TSV import with delimiter and header detection and a column
+// mapping step on the Import screen
+
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// columnEmail maps a column to the recipient address
+	columnEmail = "email"
+
+	csvSampleRows = 8
+)
+
+var (
+	csvDelimiters    = []rune{',', ';', '\t', '|'}
+	emailValueRegex  = regexp.MustCompile(`^[^@\s<>]+@[^@\s<>]+\.[^@\s<>]+$`)
+	emailHeaderNames = map[string]bool{
+		"email": true, "e_mail": true, "mail": true, "email_address": true,
+		"e_mail_address": true, "mail_address": true, "address": true,
+	}
+)
+
+// CSVImport is a CSV or TSV file, or the result of a SQLite query, whose
+// columns are being mapped to the recipient address and custom fields
+type CSVImport struct {
+	Path string
+	// Query is the SELECT run on the SQLite file at Path
+	Query     string
+	Delimiter rune
+	HasHeader bool
+	// Sample holds the first rows of the file, header row included
+	Sample [][]string
+	// Mapping holds per column columnEmail, a field name or "" to skip
+	Mapping []string
+}
+
+// isCSVFile: .csv and .tsv files go through the column mapping step
+func isCSVFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".tsv":
+		return true
+	}
+	return false
+}
+
+// OpenCSVImport reads the start of a CSV/TSV file, detects the delimiter and
+// header row and guesses a column mapping
+func OpenCSVImport(path string) (*CSVImport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() && len(lines) < 20 {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	c := &CSVImport{Path: path, Delimiter: detectDelimiter(lines, filepath.Ext(path))}
+	if err := c.readSample(); err != nil {
+		return nil, err
+	}
+	c.HasHeader = detectHeader(c.Sample)
+	c.guessMapping()
+	return c, nil
+}
+
+// detectDelimiter: Picks the candidate that splits the most sampled lines
+// into the same number of columns as the first, preferring more columns.
+// Lines are parsed as CSV so quoted delimiters do not count.
+func detectDelimiter(lines []string, ext string) rune {
+	best, bestScore, bestColumns := rune(0), 0, 0
+	for _, d := range csvDelimiters {
+		reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+		reader.Comma = d
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+
+		rows, _ := reader.ReadAll()
+		if len(rows) == 0 || len(rows[0]) < 2 {
+			continue
+		}
+		score := 0
+		for _, row := range rows {
+			if len(row) == len(rows[0]) {
+				score++
+			}
+		}
+		if score > bestScore || (score == bestScore && len(rows[0]) > bestColumns) {
+			best, bestScore, bestColumns = d, score, len(rows[0])
+		}
+	}
+	if best != 0 {
+		return best
+	}
+	if strings.EqualFold(ext, ".tsv") {
+		return '\t'
+	}
+	return ','
+}
+
+// detectHeader: The first row is a header when it holds no address but a
+// later row does
+func detectHeader(rows [][]string) bool {
+	if len(rows) == 0 || emailColumn(rows[:1]) >= 0 {
+		return false
+	}
+	return len(rows) == 1 || emailColumn(rows[1:]) >= 0
+}
+
+// emailColumn: Index of the column holding the most addresses, or -1
+func emailColumn(rows [][]string) int {
+	counts := make(map[int]int)
+	for _, row := range rows {
+		for i, cell := range row {
+			if emailValueRegex.MatchString(cleanAddress(cell)) {
+				counts[i]++
+			}
+		}
+	}
+	best := -1
+	for i, n := range counts {
+		if best == -1 || n > counts[best] || (n == counts[best] && i < best) {
+			best = i
+		}
+	}
+	return best
+}
+
+// cleanAddress: Strips whitespace, angle brackets and a mailto: prefix
+func cleanAddress(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), "<>")
+	if len(value) > 7 && strings.EqualFold(value[:7], "mailto:") {
+		value = value[7:]
+	}
+	return strings.TrimSpace(value)
+}
+
+func (c *CSVImport) newReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = c.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// open: Opens the file for reading, skipping a UTF-8 byte order mark, or
+// starts the query. Reads of the file count towards p when set.
+func (c *CSVImport) open(p *ImportProgress) (io.Closer, *csv.Reader, error) {
+	if c.Query != "" {
+		out, err := runQuery(c.Path, c.Query)
+		if err != nil {
+			return nil, nil, err
+		}
+		return out, c.newReader(out), nil
+	}
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := int64(0)
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	buffered := bufio.NewReader(p.track(file, size))
+	if bom, err := buffered.Peek(3); err == nil && bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		buffered.Discard(3)
+	}
+	return file, c.newReader(buffered), nil
+}
+
+func (c *CSVImport) readSample() error {
+	file, reader, err := c.open(nil)
+	if err != nil {
+		return err
+	}
+
+	c.Sample = nil
+	for len(c.Sample) < csvSampleRows {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+		c.Sample = append(c.Sample, row)
+	}
+	return file.Close()
+}
+
+// Source names the file, and the query of a SQLite import, in logs
+func (c *CSVImport) Source() string {
+	if c.Query != "" {
+		return fmt.Sprintf("%s (%s)", c.Path, c.Query)
+	}
+	return c.Path
+}
+
+// Columns returns the number of columns seen in the sample
+func (c *CSVImport) Columns() int {
+	columns := 0
+	for _, row := range c.Sample {
+		columns = max(columns, len(row))
+	}
+	return columns
+}
+
+// ColumnName returns the header of a column, or "column N" without header
+func (c *CSVImport) ColumnName(i int) string {
+	if c.HasHeader && len(c.Sample) > 0 && i < len(c.Sample[0]) && strings.TrimSpace(c.Sample[0][i]) != "" {
+		return strings.TrimSpace(c.Sample[0][i])
+	}
+	return fmt.Sprintf("column %d", i+1)
+}
+
+// dataRows returns the sampled rows without the header
+func (c *CSVImport) dataRows() [][]string {
+	if c.HasHeader && len(c.Sample) > 0 {
+		return c.Sample[1:]
+	}
+	return c.Sample
+}
+
+// guessMapping: Maps the address column by header name or content and, with
+// a header, every other column to a field named after it
+func (c *CSVImport) guessMapping() {
+	c.Mapping = make([]string, c.Columns())
+
+	email := -1
+	if c.HasHeader {
+		used := make(map[string]bool)
+		for i := range c.Mapping {
+			name := normalizeFieldName(c.ColumnName(i))
+			if emailHeaderNames[name] && email == -1 {
+				email = i
+				continue
+			}
+			if validFieldName(name) && !used[name] {
+				c.Mapping[i] = name
+				used[name] = true
+			}
+		}
+	}
+	if email == -1 {
+		email = emailColumn(c.dataRows())
+	}
+	if email >= 0 && email < len(c.Mapping) {
+		c.Mapping[email] = columnEmail
+	}
+}
+
+// SetColumn maps a column to the address, a custom field, or nothing ("")
+func (c *CSVImport) SetColumn(i int, target string) error {
+	if i < 0 || i >= len(c.Mapping) {
+		return fmt.Errorf("no column %d", i+1)
+	}
+	target = normalizeFieldName(target)
+	if target != "" && target != columnEmail && !validFieldName(target) {
+		return fmt.Errorf("%q cannot be used as a field name", target)
+	}
+	for j, m := range c.Mapping {
+		if j == i || m != target || target == "" {
+			continue
+		}
+		if target != columnEmail {
+			return fmt.Errorf("%s is already mapped to %s", target, c.ColumnName(j))
+		}
+		// Only one address column
+		c.Mapping[j] = ""
+	}
+	c.Mapping[i] = target
+	return nil
+}
+
+// ToggleHeader switches between treating the first row as header or data
+func (c *CSVImport) ToggleHeader() {
+	c.HasHeader = !c.HasHeader
+	c.guessMapping()
+}
+
+// NextDelimiter switches to the next candidate delimiter and re-reads the
+// sample
+func (c *CSVImport) NextDelimiter() error {
+	for i, d := range csvDelimiters {
+		if d == c.Delimiter {
+			c.Delimiter = csvDelimiters[(i+1)%len(csvDelimiters)]
+			break
+		}
+	}
+	if err := c.readSample(); err != nil {
+		return err
+	}
+	c.guessMapping()
+	return nil
+}
+
+// eachRow: Streams the rows of the file or query result with the mapping of
+// c to fn. Rows without a valid address are counted as skipped.
+func (c *CSVImport) eachRow(p *ImportProgress, fn func(ImportRow) error) (int, error) {
+	email := -1
+	for i, m := range c.Mapping {
+		if m == columnEmail {
+			email = i
+		}
+	}
+	if email == -1 {
+		return 0, errors.New("no column is mapped to the email address")
+	}
+
+	file, reader, err := c.open(p)
+	if err != nil {
+		return 0, err
+	}
+
+	skipped := 0
+	first := true
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return skipped, err
+		}
+		if first && c.HasHeader {
+			first = false
+			continue
+		}
+		first = false
+
+		if email >= len(record) || !emailValueRegex.MatchString(cleanAddress(record[email])) {
+			skipped++
+			continue
+		}
+		row := ImportRow{Email: cleanAddress(record[email]), Fields: make(map[string]string)}
+		for i, field := range c.Mapping {
+			if field == "" || field == columnEmail || i >= len(record) {
+				continue
+			}
+			if value := strings.TrimSpace(record[i]); value != "" {
+				row.Fields[field] = value
+			}
+		}
+		if err := fn(row); err != nil {
+			file.Close()
+			return skipped, err
+		}
+	}
+	// Query errors arrive through the reader, closing only stops the query
+	return skipped, file.Close()
+}
+
+// ImportCSV imports a mapped CSV/TSV file without a preview
+func (a *App) ImportCSV(c *CSVImport) error {
+	p := &ImportProgress{Source: c.Source()}
+	plan, err := a.PlanCSVImport(c, p)
+	if err != nil {
+		return err
+	}
+	_, err = a.CommitImport(plan, p)
+	return err
+}
+
+// PlanCSVImport reads a mapped CSV/TSV file or query result and plans its
+// import. The commit reads the file, or runs the query, again with the
+// mapping as it is now.
+func (a *App) PlanCSVImport(c *CSVImport, p *ImportProgress) (*ImportPlan, error) {
+	mapped := *c
+	mapped.Mapping = append([]string(nil), c.Mapping...)
+	return a.planImport(c.Source(), c.Path, mapped.eachRow, p)
+}
+
+// delimiterName: Printable name of a delimiter
+func delimiterName(d rune) string {
+	if d == '\t' {
+		return "tab"
+	}
+	return string(d)
+}
+
+// csvMappingContent: Renders the column mapping step for the Import screen
+func csvMappingContent(c *CSVImport, selected int) string {
+	header := "no"
+	if c.HasHeader {
+		header = "yes"
+	}
+	content := fmt.Sprintf("Map columns of %s (delimiter: %s, header row: %s)\n\n", c.Path, delimiterName(c.Delimiter), header)
+	if c.Query != "" {
+		content = fmt.Sprintf("Map columns of %s\n\n", c.Source())
+	}
+
+	rows := c.dataRows()
+	for i, target := range c.Mapping {
+		prefix := "  "
+		if i == selected {
+			prefix = "> "
+		}
+		switch target {
+		case "":
+			target = "(skip)"
+		case columnEmail:
+			target = "EMAIL ADDRESS"
+		default:
+			target = "field " + target
+		}
+
+		var examples []string
+		for _, row := range rows {
+			if i < len(row) && strings.TrimSpace(row[i]) != "" && len(examples) < 3 {
+				examples = append(examples, strings.TrimSpace(row[i]))
+			}
+		}
+		example := strings.Join(examples, ", ")
+		if len(example) > 40 {
+			example = example[:40] + "..."
+		}
+		content += fmt.Sprintf("%s%-20s -> %-25s %s\n", prefix, c.ColumnName(i), target, example)
+	}
+
+	content += "\nUp/Down to select, e email column, Enter set field name, - skip column\n"
+	if c.Query == "" {
+		content += "t toggle header row, Tab change delimiter, "
+	}
+	content += "y preview import, n cancel"
+	return content
+}
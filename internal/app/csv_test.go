@@ -0,0 +1,104 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		lines []string
+		ext   string
+		want  rune
+	}{
+		{[]string{"name,email", "Bob,bob@example.com"}, ".csv", ','},
+		{[]string{"name;email", "Bob;bob@example.com"}, ".csv", ';'},
+		{[]string{"name\temail", "Bob\tbob@example.com"}, ".tsv", '\t'},
+		{[]string{"name|email|city", "Bob|bob@example.com|Paris"}, ".csv", '|'},
+		// The comma inside quotes does not count
+		{[]string{`"Smith, Bob";bob@example.com`, `"Doe, Ann";ann@example.com`}, ".csv", ';'},
+		{[]string{"bob@example.com"}, ".tsv", '\t'},
+		{[]string{"bob@example.com"}, ".csv", ','},
+	}
+	for _, tt := range tests {
+		if got := detectDelimiter(tt.lines, tt.ext); got != tt.want {
+			t.Errorf("detectDelimiter(%q) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestDetectHeader(t *testing.T) {
+	tests := []struct {
+		rows [][]string
+		want bool
+	}{
+		{[][]string{{"Name", "E-mail"}, {"Bob", "bob@example.com"}}, true},
+		{[][]string{{"Bob", "bob@example.com"}, {"Ann", "ann@example.com"}}, false},
+		{[][]string{{"Name", "Notes"}, {"Bob", "none"}}, false},
+		{[][]string{{"Name", "Email"}}, true},
+	}
+	for _, tt := range tests {
+		if got := detectHeader(tt.rows); got != tt.want {
+			t.Errorf("detectHeader(%q) = %v, want %v", tt.rows, got, tt.want)
+		}
+	}
+}
+
+func TestCleanAddress(t *testing.T) {
+	for in, want := range map[string]string{
+		" <bob@example.com> ":    "bob@example.com",
+		"mailto:bob@example.com": "bob@example.com",
+		"MAILTO:bob@example.com": "bob@example.com",
+		"bob@example.com":        "bob@example.com",
+	} {
+		if got := cleanAddress(in); got != want {
+			t.Errorf("cleanAddress(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCSVImportMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.csv")
+	data := "\xEF\xBB\xBFFirst Name;E-Mail;Language;Message ID\nBob;<bob@example.com>;DE;x\nAnn;nope;fr;y\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCSVImport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Delimiter != ';' || !c.HasHeader {
+		t.Fatalf("delimiter %q, header %v", c.Delimiter, c.HasHeader)
+	}
+	// The app's own fields are not offered as targets
+	if want := []string{"first_name", columnEmail, FieldLang, ""}; !reflect.DeepEqual(c.Mapping, want) {
+		t.Errorf("mapping %q, want %q", c.Mapping, want)
+	}
+	if err := c.SetColumn(3, FieldMessageID); err == nil {
+		t.Error("mapped a column to message_id")
+	}
+	if err := c.SetColumn(0, FieldLang); err == nil {
+		t.Error("mapped two columns to lang")
+	}
+
+	var rows []ImportRow
+	skipped, err := c.eachRow(nil, func(row ImportRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImportRow{{Email: "bob@example.com", Fields: map[string]string{"first_name": "Bob", FieldLang: "DE"}}}
+	if skipped != 1 || !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %+v, skipped %d", rows, skipped)
+	}
+
+	c.ToggleHeader()
+	if c.HasHeader || c.Mapping[1] != columnEmail {
+		t.Errorf("without header: mapping %q", c.Mapping)
+	}
+}
@@ -4,19 +4,30 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var ErrNoPendingRecipients = errors.New("no pending recipients")
 
+// dbMu serializes read-modify-write cycles on the database file, which is
+// shared by the dispatcher, the watcher and the tracking server
+var dbMu sync.Mutex
+
 // dbRecord represents a parsed database line
 type dbRecord struct {
 	Timestamp time.Time
 	Status    string
 	Email     string
 	Error     string
+	Fields    map[string]string
 }
 
 // parseDBLine parses a database line into a dbRecord
@@ -47,9 +58,44 @@ func parseDBLine(line string) (*dbRecord, error) {
 		record.Error = strings.TrimSpace(parts[3])
 	}
 
+	// Any further parts are key=value fields (variant, lang, ...)
+	for _, part := range parts[min(len(parts), 4):] {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" {
+			continue
+		}
+		record.SetField(key, value)
+	}
+
 	return record, nil
 }
 
+var (
+	// A record is one line split on ";", so stored values may hold neither
+	// a ";" nor a line break, and keys no "=" either
+	fieldValueReplacer = strings.NewReplacer(";", ",", "\r\n", " ", "\r", " ", "\n", " ")
+	fieldKeyReplacer   = strings.NewReplacer(";", "", "=", "", "\r", "", "\n", "")
+)
+
+// Field returns the value of a record field, or "" if unset
+func (r *dbRecord) Field(key string) string {
+	return r.Fields[key]
+}
+
+// SetField sets a record field; an empty value removes it
+func (r *dbRecord) SetField(key, value string) {
+	key = strings.TrimSpace(fieldKeyReplacer.Replace(key))
+	value = strings.TrimSpace(fieldValueReplacer.Replace(value))
+	if value == "" {
+		delete(r.Fields, key)
+		return
+	}
+	if r.Fields == nil {
+		r.Fields = make(map[string]string)
+	}
+	r.Fields[key] = value
+}
+
 // formatDBLine formats a dbRecord into a database line
 func (r *dbRecord) String() string {
 	timestampStr := r.Timestamp.Format(time.RFC3339)
@@ -58,8 +104,17 @@ func (r *dbRecord) String() string {
 	}
 
 	line := timestampStr + " ; " + r.Status + " ; " + r.Email
-	if r.Error != "" {
-		line += " ; " + r.Error
+	if r.Error != "" || len(r.Fields) > 0 {
+		line += " ; " + fieldValueReplacer.Replace(r.Error)
+	}
+
+	keys := make([]string, 0, len(r.Fields))
+	for key := range r.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line += " ; " + key + "=" + r.Fields[key]
 	}
 	return line
 }
@@ -74,20 +129,36 @@ func NewDatabase(path string) *Database {
 	return &Database{path: path}
 }
 
+// maxLineSize is the longest database line that can be read
+const maxLineSize = 1024 * 1024
+
 // readLines reads all lines from database file
 func (db *Database) readLines() ([]string, error) {
+	var lines []string
+	err := db.scanLines(func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	return lines, err
+}
+
+// scanLines calls fn with each line of the database file, reading it a
+// line at a time
+func (db *Database) scanLines(fn func(line string) error) error {
 	file, err := os.Open(db.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 
-	var lines []string
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
 	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+		if err := fn(scanner.Text()); err != nil {
+			return err
+		}
 	}
-	return lines, scanner.Err()
+	return scanner.Err()
 }
 
 // writeLines writes all lines to database file
@@ -95,8 +166,29 @@ func (db *Database) writeLines(lines []string) error {
 	return os.WriteFile(db.path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
 }
 
-// forEach iterates over all valid records
+// forEach iterates over all valid records, reading the file a line at a
+// time. The database stays locked meanwhile, so fn must not call back into
+// it.
 func (db *Database) forEach(fn func(record *dbRecord, lineIndex int) error) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	i := -1
+	return db.scanLines(func(line string) error {
+		i++
+		record, err := parseDBLine(line)
+		if err != nil {
+			return nil
+		}
+		return fn(record, i)
+	})
+}
+
+// updateRecord updates a specific record that matches the filter
+func (db *Database) updateRecord(filter func(*dbRecord) bool, update func(*dbRecord)) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
 	lines, err := db.readLines()
 	if err != nil {
 		return err
@@ -107,20 +199,28 @@ func (db *Database) forEach(fn func(record *dbRecord, lineIndex int) error) erro
 		if err != nil {
 			continue
 		}
-		if err := fn(record, i); err != nil {
-			return err
+
+		if filter(record) {
+			update(record)
+			lines[i] = record.String()
+			break
 		}
 	}
-	return nil
+
+	return db.writeLines(lines)
 }
 
-// updateRecord updates a specific record that matches the filter
-func (db *Database) updateRecord(filter func(*dbRecord) bool, update func(*dbRecord)) error {
+// updateRecords updates every record that matches the filter
+func (db *Database) updateRecords(filter func(*dbRecord) bool, update func(*dbRecord)) (int, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
 	lines, err := db.readLines()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	count := 0
 	for i, line := range lines {
 		record, err := parseDBLine(line)
 		if err != nil {
@@ -130,51 +230,465 @@ func (db *Database) updateRecord(filter func(*dbRecord) bool, update func(*dbRec
 		if filter(record) {
 			update(record)
 			lines[i] = record.String()
-			break
+			count++
 		}
 	}
 
-	return db.writeLines(lines)
+	if count == 0 {
+		return 0, nil
+	}
+	return count, db.writeLines(lines)
+}
+
+// ImportResult summarises what an import added and skipped
+type ImportResult struct {
+	Added int
+	// FileDuplicates repeat an earlier row of the same import
+	FileDuplicates int
+	// DBDuplicates are already in the database
+	DBDuplicates int
+	// Duplicates lists the first few duplicate addresses for the report
+	Duplicates []string
+	Suppressed int
+	// Invalid addresses are stored as INVALID, Invalids lists the first few
+	// with their reason
+	Invalid  int
+	Invalids []string
+	// Warned counts added addresses with a warning, Warnings lists the
+	// first few with the warning
+	Warned   int
+	Warnings []string
+}
+
+const maxReportedDuplicates = 20
+
+func (r *ImportResult) addInvalid(email, reason string) {
+	r.Invalid++
+	if len(r.Invalids) < maxReportedDuplicates {
+		r.Invalids = append(r.Invalids, email+": "+reason)
+	}
+}
+
+func (r *ImportResult) addWarning(email, warning string) {
+	r.Warned++
+	if len(r.Warnings) < maxReportedDuplicates {
+		r.Warnings = append(r.Warnings, email+": "+warning)
+	}
+}
+
+func (r *ImportResult) addDuplicate(email string, inDB bool) {
+	if inDB {
+		r.DBDuplicates++
+	} else {
+		r.FileDuplicates++
+	}
+	if len(r.Duplicates) < maxReportedDuplicates {
+		where := "in file"
+		if inDB {
+			where = "in database"
+		}
+		r.Duplicates = append(r.Duplicates, email+" ("+where+")")
+	}
+}
+
+// add: Adds the counts of another result, such as that of the next chunk of
+// a large import, keeping the first few duplicates and invalid addresses
+func (r *ImportResult) add(o ImportResult) {
+	r.Added += o.Added
+	r.FileDuplicates += o.FileDuplicates
+	r.DBDuplicates += o.DBDuplicates
+	r.Suppressed += o.Suppressed
+	r.Invalid += o.Invalid
+	r.Warned += o.Warned
+	for _, d := range o.Duplicates {
+		if len(r.Duplicates) < maxReportedDuplicates {
+			r.Duplicates = append(r.Duplicates, d)
+		}
+	}
+	for _, invalid := range o.Invalids {
+		if len(r.Invalids) < maxReportedDuplicates {
+			r.Invalids = append(r.Invalids, invalid)
+		}
+	}
+	for _, warning := range o.Warnings {
+		if len(r.Warnings) < maxReportedDuplicates {
+			r.Warnings = append(r.Warnings, warning)
+		}
+	}
+}
+
+// RecipientKeys tracks the address keys of the database and of the rows of
+// an import seen so far. Keys are kept as 64-bit hashes so that millions of
+// them fit in memory.
+type RecipientKeys struct {
+	key    func(string) string
+	inDB   map[uint64]bool
+	inFile map[uint64]bool
+}
+
+// hashKey: FNV-1a hash of an address key
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// LoadRecipientKeys reads the address key of every record, one line at a
+// time. key maps an address to its deduplication key.
+func LoadRecipientKeys(path string, key func(string) string) (*RecipientKeys, error) {
+	keys := &RecipientKeys{key: key, inDB: make(map[uint64]bool), inFile: make(map[uint64]bool)}
+
+	err := NewDatabase(path).forEach(func(record *dbRecord, _ int) error {
+		keys.inDB[hashKey(key(record.Email))] = true
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// duplicate reports whether an address repeats a record (inDB) or an earlier
+// row, and remembers it otherwise
+func (k *RecipientKeys) duplicate(email string) (bool, bool) {
+	key := hashKey(k.key(email))
+	if k.inDB[key] {
+		return true, true
+	}
+	if k.inFile[key] {
+		return true, false
+	}
+	k.inFile[key] = true
+	return false, false
+}
+
+// Preview tells what AddRecipients would do with the rows without writing
+// anything. Returns the expected result and a note per row: "new",
+// "invalid: reason", "duplicate in file" or "already in database".
+func (k *RecipientKeys) Preview(rows []ImportRow) (ImportResult, []string) {
+	var result ImportResult
+	notes := make([]string, len(rows))
+	for i, row := range rows {
+		if dup, inDB := k.duplicate(row.Email); dup {
+			result.addDuplicate(row.Email, inDB)
+			notes[i] = "duplicate in file"
+			if inDB {
+				notes[i] = "already in database"
+			}
+			continue
+		}
+		if row.Invalid != "" {
+			result.addInvalid(row.Email, row.Invalid)
+			notes[i] = "invalid: " + row.Invalid
+			continue
+		}
+		result.Added++
+		notes[i] = "new"
+		if row.Warning != "" {
+			result.addWarning(row.Email, row.Warning)
+			notes[i] = "new, " + row.Warning
+		}
+	}
+	return result, notes
+}
+
+// AddRecipients appends a PENDING (or, for rows that failed validation,
+// INVALID) record for every row whose address key is not in keys yet, and
+// counts duplicates within the rows and against the database. Large imports
+// call it once per chunk of rows with the same keys.
+func AddRecipients(path string, rows []ImportRow, keys *RecipientKeys) (ImportResult, error) {
+	var result ImportResult
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+
+	writer := bufio.NewWriter(f)
+	// Hand-edited files may lack the final newline
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			writer.WriteString("\n")
+		}
+	}
+	now := time.Now()
+	for _, row := range rows {
+		if dup, inDB := keys.duplicate(row.Email); dup {
+			result.addDuplicate(row.Email, inDB)
+			continue
+		}
+
+		record := &dbRecord{Timestamp: now, Status: StatusPending, Email: row.Email}
+		if row.Invalid != "" {
+			record.Status = StatusInvalid
+			record.Error = strings.ReplaceAll(row.Invalid, ";", ",")
+		}
+		for k, v := range row.Fields {
+			record.SetField(k, v)
+		}
+		record.SetField(FieldWarning, row.Warning)
+		if _, err := writer.WriteString(record.String() + "\n"); err != nil {
+			return result, err
+		}
+		if row.Invalid != "" {
+			result.addInvalid(row.Email, row.Invalid)
+		} else {
+			result.Added++
+		}
+		if row.Warning != "" {
+			result.addWarning(row.Email, row.Warning)
+		}
+	}
+	return result, writer.Flush()
+}
+
+// knownStatuses are the statuses a record may have
+var knownStatuses = map[string]bool{
+	StatusPending: true, StatusSending: true, StatusDone: true, StatusFailed: true,
+	StatusUnsubscribed: true, StatusSuppressed: true, StatusBounced: true,
+	StatusComplained: true, StatusInvalid: true,
 }
 
-func GetNextPending(path string) (*Recipient, error) {
+// isBareLine: A line holding only an address, added to the file by hand
+func isBareLine(line string) bool {
+	return !strings.Contains(line, ";") && strings.Contains(line, "@")
+}
+
+// parseBareLine: Address and status of a bare line. Earlier versions
+// imported addresses as "address PENDING", which keep their status; a
+// send that never finished is tried again.
+func parseBareLine(line string) (string, string) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || !knownStatuses[strings.ToUpper(fields[1])] {
+		return line, StatusPending
+	}
+	status := strings.ToUpper(fields[1])
+	if status == StatusSending {
+		status = StatusPending
+	}
+	return fields[0], status
+}
+
+// ConvertBareLines turns lines holding only an address (added to the file by
+// hand) into PENDING records, or INVALID ones when check returns a reason.
+// Lines in the "address STATUS" form of earlier versions keep their status.
+// Lines whose address key is already in the file are dropped. Returns the
+// converted and the dropped addresses.
+//
+// The file is read a line at a time. Only when it has bare lines are the
+// keys of its records collected, as hashes, and the file rewritten through a
+// temporary copy.
+func ConvertBareLines(path string, key func(string) string, check func(string) string) ([]string, []string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	db := NewDatabase(path)
+	errFound := errors.New("bare line found")
+	err := db.scanLines(func(line string) error {
+		if isBareLine(strings.TrimSpace(line)) {
+			return errFound
+		}
+		return nil
+	})
+	if err == nil || !errors.Is(err, errFound) {
+		return nil, nil, err
+	}
+
+	seen := make(map[uint64]bool)
+	err = db.scanLines(func(line string) error {
+		if record, err := parseDBLine(line); err == nil {
+			seen[hashKey(key(record.Email))] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".convert-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var converted, dropped []string
+	writer := bufio.NewWriter(tmp)
+	err = db.scanLines(func(line string) error {
+		line = strings.TrimSpace(line)
+		if line == "" || !isBareLine(line) {
+			_, err := writer.WriteString(line + "\n")
+			return err
+		}
+		address, status := parseBareLine(line)
+		record := &dbRecord{Status: status, Email: address}
+		if email, err := normalizeAddress(address); err != nil {
+			record.Status, record.Error = StatusInvalid, err.Error()
+		} else if reason := check(email); reason != "" && status == StatusPending {
+			record.Email, record.Status, record.Error = email, StatusInvalid, reason
+		} else {
+			record.Email = email
+		}
+		k := hashKey(key(record.Email))
+		if seen[k] {
+			dropped = append(dropped, record.Email)
+			return nil
+		}
+		seen[k] = true
+		converted = append(converted, record.Email)
+		_, err := writer.WriteString(record.String() + "\n")
+		return err
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, dropped, copyBack(tmp, path)
+}
+
+// copyBack: Replaces the content of path with that of tmp. The file is
+// rewritten in place rather than renamed, so the watcher keeps watching it.
+func copyBack(tmp *os.File, path string) error {
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, tmp); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ClaimOptions controls how GetNextPending claims a recipient
+type ClaimOptions struct {
+	// Variants to assign; an earlier assignment is kept if still on offer
+	Variants []MailVariant
+	// Suppressions are checked before claiming; matching records are
+	// marked SUPPRESSED instead of being sent
+	Suppressions *SuppressionList
+	// OnSuppressed is called for every record marked SUPPRESSED
+	OnSuppressed func(email string, entry SuppressionEntry)
+	// MessageID generates the Message-ID stored on the claimed record,
+	// unless it has one from an earlier attempt
+	MessageID func() (string, error)
+}
+
+// suppressedRecord is a record marked SUPPRESSED while claiming
+type suppressedRecord struct {
+	email string
+	entry SuppressionEntry
+}
+
+// GetNextPending claims the first sendable PENDING record as SENDING. When
+// variants are given, the record is assigned one of them and the choice is
+// stored on the record.
+func GetNextPending(path string, opts ClaimOptions) (*Recipient, error) {
+	recipient, suppressed, err := claimNext(path, opts)
+
+	// Callbacks run after the database lock is released
+	if opts.OnSuppressed != nil {
+		for _, s := range suppressed {
+			opts.OnSuppressed(s.email, s.entry)
+		}
+	}
+	return recipient, err
+}
+
+func claimNext(path string, opts ClaimOptions) (*Recipient, []suppressedRecord, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
 	db := NewDatabase(path)
 	lines, err := db.readLines()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	var suppressed []suppressedRecord
+
+	changed := false
 	for i, line := range lines {
 		record, err := parseDBLine(line)
 		if err != nil {
 			continue
 		}
+		if record.Status != StatusPending {
+			continue
+		}
 
-		if record.Status == StatusPending {
+		if entry, ok := opts.Suppressions.Match(record.Email); ok {
 			record.Timestamp = time.Now()
-			record.Status = StatusSending
+			record.Status = StatusSuppressed
+			record.Error = strings.ReplaceAll("suppressed: "+firstNonEmpty(entry.Reason, entry.Entry), ";", ",")
 			lines[i] = record.String()
+			changed = true
+			suppressed = append(suppressed, suppressedRecord{email: record.Email, entry: entry})
+			continue
+		}
 
-			if err := db.writeLines(lines); err != nil {
-				return nil, err
+		// A retry keeps the Message-ID, so bounces of the earlier attempt
+		// still match the record
+		if opts.MessageID != nil && record.Field(FieldMessageID) == "" {
+			id, err := opts.MessageID()
+			if err != nil {
+				return nil, suppressed, err
 			}
+			record.SetField(FieldMessageID, id)
+		}
+		record.Timestamp = time.Now()
+		record.Status = StatusSending
+		if len(opts.Variants) > 0 && !hasVariant(opts.Variants, record.Field(FieldVariant)) {
+			record.SetField(FieldVariant, pickVariant(record.Email, opts.Variants))
+		}
+		lines[i] = record.String()
 
-			return &Recipient{
-				Email:  record.Email,
-				Status: StatusPending,
-			}, nil
+		if err := db.writeLines(lines); err != nil {
+			return nil, suppressed, err
 		}
+
+		return &Recipient{
+			Email:   record.Email,
+			Status:  StatusPending,
+			Variant: record.Field(FieldVariant),
+			Fields:  record.Fields,
+		}, suppressed, nil
 	}
-	return nil, ErrNoPendingRecipients
+
+	if changed {
+		if err := db.writeLines(lines); err != nil {
+			return nil, suppressed, err
+		}
+	}
+	return nil, suppressed, ErrNoPendingRecipients
 }
 
+// UpdateStatus records the outcome of a claimed recipient. Only the SENDING
+// record is changed, so an unsubscribe or complaint recorded while the
+// message was on its way is kept.
 func UpdateStatus(path, email, status, errorMsg string) error {
 	db := NewDatabase(path)
 	return db.updateRecord(
-		func(r *dbRecord) bool { return r.Email == email },
+		func(r *dbRecord) bool { return r.Email == email && r.Status == StatusSending },
 		func(r *dbRecord) {
 			r.Timestamp = time.Now()
 			r.Status = status
+			if status == StatusDone {
+				r.SetField(FieldSentAt, r.Timestamp.Format(time.RFC3339))
+			}
 			if errorMsg != "" {
 				r.Error = strings.ReplaceAll(errorMsg, ";", ",")
 			}
@@ -182,6 +696,191 @@ func UpdateStatus(path, email, status, errorMsg string) error {
 	)
 }
 
+// unsubscribable are the statuses an unsubscribe replaces. The others record
+// an outcome that must stay counted, such as a complaint or a bounce, or a
+// finding about the address.
+var unsubscribable = map[string]bool{StatusPending: true, StatusSending: true, StatusDone: true}
+
+// MarkUnsubscribed sets the pending, sending and sent records of an address
+// to UNSUBSCRIBED
+func MarkUnsubscribed(path, email string) (int, error) {
+	db := NewDatabase(path)
+	now := time.Now()
+	return db.updateRecords(
+		func(r *dbRecord) bool { return strings.EqualFold(r.Email, email) && unsubscribable[r.Status] },
+		func(r *dbRecord) {
+			r.Timestamp = now
+			r.Status = StatusUnsubscribed
+		},
+	)
+}
+
+// BounceMatch identifies the record a bounce belongs to: the stored
+// Message-ID when known, otherwise the recipient address
+type BounceMatch struct {
+	MessageID string
+	Email     string
+}
+
+// MarkBounced sets the matching sent records to BOUNCED with the hard/soft
+// classification and diagnostic. Returns the number of records updated and
+// the matched address.
+func MarkBounced(path string, match BounceMatch, kind, diagnostic string) (int, string, error) {
+	db := NewDatabase(path)
+	now := time.Now()
+	email := ""
+
+	sent := func(r *dbRecord) bool {
+		return r.Status == StatusDone || r.Status == StatusSending || r.Status == StatusBounced
+	}
+	byMessageID := func(r *dbRecord) bool {
+		return sent(r) && r.Field(FieldMessageID) == match.MessageID
+	}
+	byEmail := func(r *dbRecord) bool {
+		return sent(r) && strings.EqualFold(r.Email, match.Email)
+	}
+
+	update := func(r *dbRecord) {
+		// A hard bounce is never downgraded by a later soft one
+		if r.Status == StatusBounced && r.Field(FieldBounce) == BounceHard && kind == BounceSoft {
+			return
+		}
+		r.Timestamp = now
+		r.Status = StatusBounced
+		r.Error = strings.ReplaceAll(diagnostic, ";", ",")
+		r.SetField(FieldBounce, kind)
+		email = r.Email
+	}
+
+	count := 0
+	var err error
+	if match.MessageID != "" {
+		count, err = db.updateRecords(byMessageID, update)
+	}
+	if count == 0 && err == nil && match.Email != "" {
+		count, err = db.updateRecords(byEmail, update)
+	}
+	if email == "" {
+		email = match.Email
+	}
+	return count, email, err
+}
+
+// MarkComplained sets the matching sent records to COMPLAINED and stores the
+// feedback type. Matching works like MarkBounced. Returns the number of
+// records updated and the matched address.
+func MarkComplained(path string, match BounceMatch, feedbackType string) (int, string, error) {
+	db := NewDatabase(path)
+	now := time.Now()
+	email := ""
+
+	sent := func(r *dbRecord) bool {
+		switch r.Status {
+		case StatusDone, StatusSending, StatusBounced, StatusUnsubscribed, StatusComplained:
+			return true
+		}
+		return false
+	}
+	byMessageID := func(r *dbRecord) bool {
+		return sent(r) && r.Field(FieldMessageID) == match.MessageID
+	}
+	byEmail := func(r *dbRecord) bool {
+		return sent(r) && strings.EqualFold(r.Email, match.Email)
+	}
+
+	update := func(r *dbRecord) {
+		r.Timestamp = now
+		r.Status = StatusComplained
+		r.SetField(FieldComplaint, strings.ReplaceAll(feedbackType, ";", ","))
+		email = r.Email
+	}
+
+	count := 0
+	var err error
+	if match.MessageID != "" {
+		count, err = db.updateRecords(byMessageID, update)
+	}
+	if count == 0 && err == nil && match.Email != "" {
+		count, err = db.updateRecords(byEmail, update)
+	}
+	if email == "" {
+		email = match.Email
+	}
+	return count, email, err
+}
+
+// RecordClick increments the click count of a link on a recipient's record
+func RecordClick(path, email, linkID string) error {
+	db := NewDatabase(path)
+	return db.updateRecord(
+		func(r *dbRecord) bool { return r.Email == email },
+		func(r *dbRecord) {
+			clicks := parseCounts(r.Field(FieldClicks))
+			clicks[linkID]++
+			r.SetField(FieldClicks, formatCounts(clicks))
+		},
+	)
+}
+
+// RecordOpen stores the first open time and increments the open count
+func RecordOpen(path, email string, at time.Time) error {
+	db := NewDatabase(path)
+	return db.updateRecord(
+		func(r *dbRecord) bool { return r.Email == email },
+		func(r *dbRecord) {
+			if r.Field(FieldFirstOpen) == "" {
+				r.SetField(FieldFirstOpen, at.UTC().Format(time.RFC3339))
+			}
+			opens, _ := strconv.Atoi(r.Field(FieldOpens))
+			r.SetField(FieldOpens, strconv.Itoa(opens+1))
+		},
+	)
+}
+
+// parseCounts parses a "key:count,key:count" field value
+func parseCounts(value string) map[string]int {
+	counts := make(map[string]int)
+	for _, part := range strings.Split(value, ",") {
+		key, n, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || key == "" {
+			continue
+		}
+		count, err := strconv.Atoi(n)
+		if err != nil {
+			continue
+		}
+		counts[key] += count
+	}
+	return counts
+}
+
+// formatCounts formats counts as a "key:count,key:count" field value
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+":"+strconv.Itoa(counts[key]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// wasDelivered: The message of the record was sent. Records sent before
+// sent_at was stored count if DONE, BOUNCED or COMPLAINED.
+func wasDelivered(r *dbRecord) bool {
+	switch r.Status {
+	case StatusDone, StatusBounced, StatusComplained:
+		return true
+	case StatusUnsubscribed:
+		return r.Field(FieldSentAt) != ""
+	}
+	return false
+}
+
 func GetStats(path string) (*Stats, error) {
 	db := NewDatabase(path)
 	stats := &Stats{}
@@ -199,6 +898,86 @@ func GetStats(path string) (*Stats, error) {
 			stats.Failed++
 		case StatusUnsubscribed:
 			stats.Unsubscribed++
+		case StatusSuppressed:
+			stats.Suppressed++
+		case StatusBounced:
+			stats.Bounced++
+			if record.Field(FieldBounce) == BounceHard {
+				stats.HardBounced++
+			}
+		case StatusComplained:
+			stats.Complained++
+		case StatusInvalid:
+			stats.Invalid++
+		}
+		if wasDelivered(record) {
+			stats.Delivered++
+		}
+
+		if name := record.Field(FieldVariant); name != "" {
+			if stats.Variants == nil {
+				stats.Variants = make(map[string]*VariantStats)
+			}
+			vs, ok := stats.Variants[name]
+			if !ok {
+				vs = &VariantStats{}
+				stats.Variants[name] = vs
+			}
+			// Records that bounced, complained or unsubscribed after the
+			// send keep their clicks and opens, so they count as sent too
+			if wasDelivered(record) {
+				vs.Sent++
+			} else if record.Status == StatusFailed {
+				vs.Failed++
+			}
+			if record.Field(FieldClicks) != "" {
+				vs.Clicked++
+			}
+			if record.Field(FieldFirstOpen) != "" {
+				vs.Opened++
+			}
+		}
+
+		if record.Field(FieldFirstOpen) != "" {
+			stats.Opened++
+			opens, _ := strconv.Atoi(record.Field(FieldOpens))
+			stats.Opens += opens
+		}
+
+		if clicks := record.Field(FieldClicks); clicks != "" {
+			stats.Clicked++
+			if stats.Links == nil {
+				stats.Links = make(map[string]*LinkStats)
+			}
+			for id, n := range parseCounts(clicks) {
+				ls, ok := stats.Links[id]
+				if !ok {
+					ls = &LinkStats{}
+					stats.Links[id] = ls
+				}
+				ls.Unique++
+				ls.Total += n
+			}
+		}
+
+		if lang := normalizeLang(record.Field(FieldLang)); lang != "" {
+			if stats.Languages == nil {
+				stats.Languages = make(map[string]*LanguageStats)
+			}
+			ls, ok := stats.Languages[lang]
+			if !ok {
+				ls = &LanguageStats{}
+				stats.Languages[lang] = ls
+			}
+			ls.Total++
+			switch record.Status {
+			case StatusPending:
+				ls.Pending++
+			case StatusDone:
+				ls.Sent++
+			case StatusFailed:
+				ls.Failed++
+			}
 		}
 		return nil
 	})
@@ -220,25 +999,157 @@ func GetLastSentTime(path string) (time.Time, error) {
 	return lastTime, err
 }
 
-func GetPendingEmails(path string) ([]PendingEmail, error) {
+// GetPendingEmails returns the first limit PENDING and SENDING records and
+// how many there are in all
+func GetPendingEmails(path string, limit int) ([]PendingEmail, int, error) {
 	db := NewDatabase(path)
 	var pendingEmails []PendingEmail
+	total := 0
 
 	err := db.forEach(func(record *dbRecord, _ int) error {
 		if record.Status == StatusPending || record.Status == StatusSending {
-			pendingEmails = append(pendingEmails, PendingEmail{
+			total++
+			if len(pendingEmails) < limit {
+				pendingEmails = append(pendingEmails, PendingEmail{
+					Email:     record.Email,
+					IsSending: record.Status == StatusSending,
+				})
+			}
+		}
+		return nil
+	})
+
+	return pendingEmails, total, err
+}
+
+// SearchRecords returns records whose address or Message-ID contains the
+// query (case-insensitive), at most limit of them
+func SearchRecords(path, query string, limit int) ([]RecordInfo, error) {
+	db := NewDatabase(path)
+	query = strings.ToLower(strings.Trim(strings.TrimSpace(query), "<>"))
+	var results []RecordInfo
+	if query == "" {
+		return results, nil
+	}
+
+	err := db.forEach(func(record *dbRecord, _ int) error {
+		if len(results) >= limit {
+			return nil
+		}
+		if strings.Contains(strings.ToLower(record.Email), query) ||
+			strings.Contains(strings.ToLower(record.Field(FieldMessageID)), query) {
+			results = append(results, RecordInfo{
+				Timestamp: record.Timestamp,
+				Status:    record.Status,
 				Email:     record.Email,
-				IsSending: record.Status == StatusSending,
+				Error:     record.Error,
+				Fields:    record.Fields,
 			})
 		}
 		return nil
 	})
+	return results, err
+}
+
+// ExportRecords calls fn with every record whose status is in statuses, or
+// with every record when statuses is empty
+func ExportRecords(path string, statuses map[string]bool, fn func(RecordInfo) error) error {
+	db := NewDatabase(path)
+	return db.forEach(func(record *dbRecord, _ int) error {
+		if len(statuses) > 0 && !statuses[record.Status] {
+			return nil
+		}
+		return fn(RecordInfo{
+			Timestamp: record.Timestamp,
+			Status:    record.Status,
+			Email:     record.Email,
+			Error:     record.Error,
+			Fields:    record.Fields,
+		})
+	})
+}
+
+// RequeueRecords sets records with one of the statuses back to PENDING and
+// clears their error. When emails is not empty, only records whose
+// (lowercased) address is in it are requeued. Hard bounces are left alone.
+func RequeueRecords(path string, statuses, emails map[string]bool) (int, error) {
+	db := NewDatabase(path)
+	return db.updateRecords(func(record *dbRecord) bool {
+		if !statuses[record.Status] || (len(emails) > 0 && !emails[strings.ToLower(record.Email)]) {
+			return false
+		}
+		return record.Status != StatusBounced || record.Field(FieldBounce) != BounceHard
+	}, func(record *dbRecord) {
+		record.Timestamp = time.Now()
+		record.Status = StatusPending
+		record.Error = ""
+	})
+}
+
+// DatabaseProblem is a line of the database that cannot be sent as it is
+type DatabaseProblem struct {
+	Line    int    `json:"line"`
+	Email   string `json:"email,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// CheckDatabase reports malformed lines, unknown statuses and pending
+// addresses (including bare ones) for which check returns a reason
+func CheckDatabase(path string, check func(string) string) ([]DatabaseProblem, error) {
+	dbMu.Lock()
+	lines, err := NewDatabase(path).readLines()
+	dbMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
 
-	return pendingEmails, err
+	var problems []DatabaseProblem
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		email := line
+		if !strings.Contains(line, ";") {
+			address, status := parseBareLine(line)
+			if status != StatusPending {
+				continue
+			}
+			email = address
+		} else {
+			record, err := parseDBLine(line)
+			if err != nil {
+				problems = append(problems, DatabaseProblem{Line: i + 1, Problem: err.Error()})
+				continue
+			}
+			if !knownStatuses[record.Status] {
+				problems = append(problems, DatabaseProblem{Line: i + 1, Email: record.Email, Problem: fmt.Sprintf("unknown status %q", record.Status)})
+				continue
+			}
+			if record.Status != StatusPending {
+				continue
+			}
+			email = record.Email
+		}
+		normalized, err := normalizeAddress(email)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = check(normalized)
+		}
+		if reason != "" {
+			problems = append(problems, DatabaseProblem{Line: i + 1, Email: email, Problem: reason})
+		}
+	}
+	return problems, nil
 }
 
 // ResetStuckSending resets SENDING status to PENDING if older than timeout
 func ResetStuckSending(path string, timeout time.Duration) (int, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
 	db := NewDatabase(path)
 	lines, err := db.readLines()
 	if err != nil {
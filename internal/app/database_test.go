@@ -0,0 +1,314 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	record := &dbRecord{
+		Timestamp: time.Date(2026, 1, 3, 10, 30, 0, 0, time.UTC),
+		Status:    StatusFailed,
+		Email:     "bob@example.com",
+		Error:     "550 mailbox full\r\n550 try later",
+	}
+	record.SetField("note", "line1\nPENDING ; x@example.com")
+	record.SetField("a=b", "c")
+	record.SetField("empty", "  ")
+
+	line := record.String()
+	if strings.ContainsAny(line, "\r\n") {
+		t.Fatalf("record spans lines: %q", line)
+	}
+	parsed, err := parseDBLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Status != record.Status || parsed.Email != record.Email || !parsed.Timestamp.Equal(record.Timestamp) {
+		t.Errorf("parsed %+v from %q", parsed, line)
+	}
+	if parsed.Error != "550 mailbox full 550 try later" {
+		t.Errorf("error %q", parsed.Error)
+	}
+	want := map[string]string{"note": "line1 PENDING , x@example.com", "ab": "c"}
+	if len(parsed.Fields) != len(want) {
+		t.Errorf("fields %v, want %v", parsed.Fields, want)
+	}
+	for key, value := range want {
+		if parsed.Field(key) != value {
+			t.Errorf("field %s = %q, want %q", key, parsed.Field(key), value)
+		}
+	}
+	if parsed.String() != line {
+		t.Errorf("second round trip %q, want %q", parsed.String(), line)
+	}
+}
+
+func TestMultiLineFieldDoesNotAddRecipients(t *testing.T) {
+	a := newTestApp(t)
+	path := writeTestFile(t, a, "list.csv", "email,note\nbob@example.com,\"line1\nPENDING\neve@example.com\"\n")
+
+	c, err := OpenCSVImport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ImportCSV(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UpdateDataFile(a.cfg.Database.Path); err != nil {
+		t.Fatal(err)
+	}
+	records := readTestRecords(t, a)
+	if len(records) != 1 || records[0].Email != "bob@example.com" {
+		t.Fatalf("records %+v", records)
+	}
+	if note := records[0].Field("note"); note != "line1 PENDING eve@example.com" {
+		t.Errorf("note %q", note)
+	}
+}
+
+func TestImportCannotSetSystemFields(t *testing.T) {
+	for _, name := range []string{FieldVariant, FieldMessageID, FieldImportID, FieldWarning} {
+		if validFieldName(name) {
+			t.Errorf("import may set %s", name)
+		}
+	}
+}
+
+func TestGetPendingEmailsLimit(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; PENDING ; a@example.com",
+		"2026-01-01T00:00:00Z ; DONE ; b@example.com",
+		"2026-01-01T00:00:00Z ; SENDING ; c@example.com",
+		"2026-01-01T00:00:00Z ; PENDING ; d@example.com",
+	)
+	pending, total, err := GetPendingEmails(a.cfg.Database.Path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PendingEmail{{Email: "a@example.com"}, {Email: "c@example.com", IsSending: true}}
+	if total != 3 || len(pending) != 2 || pending[0] != want[0] || pending[1] != want[1] {
+		t.Errorf("pending %+v of %d", pending, total)
+	}
+}
+
+func TestConvertBareLinesKeepsFile(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; DONE ; bob@example.com",
+		"ann@example.com",
+		"BOB@example.com",
+		"",
+	)
+	path := a.cfg.Database.Path
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, dropped, err := ConvertBareLines(path, strings.ToLower, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 1 || converted[0] != "ann@example.com" || len(dropped) != 1 {
+		t.Errorf("converted %v, dropped %v", converted, dropped)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewritten in place, so a watcher on the file keeps working
+	if !os.SameFile(before, after) {
+		t.Error("database file was replaced")
+	}
+	if entries, _ := os.ReadDir(a.cfg.dir); len(entries) != 1 {
+		t.Errorf("left temporary files: %v", entries)
+	}
+
+	// Nothing to convert leaves the file alone
+	converted, dropped, err = ConvertBareLines(path, strings.ToLower, func(string) string { return "" })
+	if err != nil || converted != nil || dropped != nil {
+		t.Errorf("second pass: %v %v %v", converted, dropped, err)
+	}
+}
+
+func TestConvertBareLinesMigratesLegacyLines(t *testing.T) {
+	// Lines as written by the importer of earlier versions
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; DONE ; sent@example.com",
+		"bob@example.com PENDING",
+		"ann@example.com DONE",
+		"cid@example.com SENDING",
+		"sent@example.com PENDING",
+		"dan@example.com",
+	)
+	converted, dropped, err := ConvertBareLines(a.cfg.Database.Path, strings.ToLower, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 4 || len(dropped) != 1 || dropped[0] != "sent@example.com" {
+		t.Errorf("converted %v, dropped %v", converted, dropped)
+	}
+	want := map[string]string{
+		"sent@example.com": StatusDone,
+		"bob@example.com":  StatusPending,
+		"ann@example.com":  StatusDone,
+		"cid@example.com":  StatusPending,
+		"dan@example.com":  StatusPending,
+	}
+	records := readTestRecords(t, a)
+	if len(records) != len(want) {
+		t.Fatalf("records %+v", records)
+	}
+	for _, record := range records {
+		if want[record.Email] != record.Status {
+			t.Errorf("%q is %s, want %s", record.Email, record.Status, want[record.Email])
+		}
+	}
+
+	problems, err := CheckDatabase(a.cfg.Database.Path, func(string) string { return "" })
+	if err != nil || len(problems) != 0 {
+		t.Errorf("problems %+v, %v", problems, err)
+	}
+}
+
+func TestUpdateStatusKeepsUnsubscribe(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; SENDING ; left@example.com",
+		"2026-01-01T00:00:00Z ; SENDING ; stays@example.com",
+	)
+	path := a.cfg.Database.Path
+
+	// The recipient unsubscribes while the message is on its way
+	if _, err := MarkUnsubscribed(path, "left@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"left@example.com", "stays@example.com"} {
+		if err := UpdateStatus(path, email, StatusDone, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := readTestRecords(t, a)
+	if records[0].Status != StatusUnsubscribed {
+		t.Errorf("left@example.com is %s, want %s", records[0].Status, StatusUnsubscribed)
+	}
+	if records[1].Status != StatusDone {
+		t.Errorf("stays@example.com is %s, want %s", records[1].Status, StatusDone)
+	}
+}
+
+func TestClaimKeepsMessageID(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; PENDING ; retry@example.com ; ; message_id=<first@example.com>",
+		"2026-01-01T00:00:00Z ; PENDING ; new@example.com",
+	)
+	generated := 0
+	opts := ClaimOptions{MessageID: func() (string, error) {
+		generated++
+		return "<second@example.com>", nil
+	}}
+
+	for _, want := range []string{"<first@example.com>", "<second@example.com>"} {
+		recipient, err := GetNextPending(a.cfg.Database.Path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := recipient.Fields[FieldMessageID]; got != want {
+			t.Errorf("%s claimed with Message-ID %q, want %q", recipient.Email, got, want)
+		}
+	}
+	if generated != 1 {
+		t.Errorf("generated %d Message-IDs, want 1", generated)
+	}
+}
+
+func TestClaimFailsWithoutMessageID(t *testing.T) {
+	a := newTestApp(t, "2026-01-01T00:00:00Z ; PENDING ; new@example.com")
+	opts := ClaimOptions{MessageID: func() (string, error) {
+		return "", errors.New("no randomness")
+	}}
+	if _, err := GetNextPending(a.cfg.Database.Path, opts); err == nil {
+		t.Fatal("claim succeeded without a Message-ID")
+	}
+	if records := readTestRecords(t, a); records[0].Status != StatusPending {
+		t.Errorf("record is %s, want it left %s", records[0].Status, StatusPending)
+	}
+}
+
+func TestMarkUnsubscribedKeepsOutcomes(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; DONE ; ann@example.com",
+		"2026-01-01T00:00:00Z ; COMPLAINED ; ann@example.com ; ; complaint=abuse",
+		"2026-01-01T00:00:00Z ; BOUNCED ; ann@example.com ; 550 gone ; bounce=hard",
+		"2026-01-01T00:00:00Z ; PENDING ; Ann@Example.com",
+	)
+	count, err := MarkUnsubscribed(a.cfg.Database.Path, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("MarkUnsubscribed = %d, want 2", count)
+	}
+	want := []string{StatusUnsubscribed, StatusComplained, StatusBounced, StatusUnsubscribed}
+	for i, r := range readTestRecords(t, a) {
+		if r.Status != want[i] {
+			t.Errorf("record %d is %s, want %s", i, r.Status, want[i])
+		}
+	}
+}
+
+func TestGetStatsDelivered(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; SENDING ; a@example.com",
+		"2026-01-01T00:00:00Z ; PENDING ; b@example.com",
+		"2026-01-01T00:00:00Z ; PENDING ; c@example.com",
+		"2026-01-01T00:00:00Z ; BOUNCED ; d@example.com ; 550 ; bounce=hard",
+		"2026-01-01T00:00:00Z ; COMPLAINED ; e@example.com ; ; complaint=abuse",
+		"2026-01-01T00:00:00Z ; FAILED ; f@example.com ; timeout",
+	)
+	path := a.cfg.Database.Path
+	if err := UpdateStatus(path, "a@example.com", StatusDone, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Delivered, then unsubscribed; c unsubscribes before being sent
+	for _, email := range []string{"a@example.com", "c@example.com"} {
+		if _, err := MarkUnsubscribed(path, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := GetStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Delivered != 3 || stats.Unsubscribed != 2 {
+		t.Errorf("delivered %d, unsubscribed %d; want 3, 2", stats.Delivered, stats.Unsubscribed)
+	}
+	if rate := complaintRate(*stats); rate < 33.3 || rate > 33.4 {
+		t.Errorf("complaintRate = %.2f, want 1 of 3", rate)
+	}
+}
+
+func TestLoadRecipientKeysLongLines(t *testing.T) {
+	// Longer than the default 64 KiB token of a bufio.Scanner
+	note := strings.Repeat("x", 100*1024)
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; PENDING ; long@example.com ;  ; note="+note,
+		"2026-01-01T00:00:00Z ; PENDING ; after@example.com",
+	)
+	keys, err := LoadRecipientKeys(a.cfg.Database.Path, strings.ToLower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"long@example.com", "after@example.com"} {
+		if dup, inDB := keys.duplicate(email); !dup || !inDB {
+			t.Errorf("%s not found in the database", email)
+		}
+	}
+
+	if _, err := LoadRecipientKeys(a.cfg.Database.Path+".missing", strings.ToLower); err != nil {
+		t.Errorf("missing database: %v", err)
+	}
+}
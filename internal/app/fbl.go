@@ -0,0 +1,125 @@
+// fbl.go: Processes feedback-loop abuse complaints sent by mailbox providers
+// in the Abuse Reporting Format (RFC 5965)
+
+package app
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+const (
+	defaultComplaintMinSent = 100
+	complaintReason         = "complaint"
+)
+
+// Complaint is a parsed feedback report for one recipient
+type Complaint struct {
+	Recipient    string
+	MessageID    string
+	FeedbackType string
+}
+
+// parseComplaint: Extracts the complained-about recipient of an ARF report.
+// ok is false for messages that are not feedback reports. pattern is the
+// smtp.return_path VERP pattern, if any.
+func parseComplaint(msg *mail.Message, pattern string) (Complaint, bool) {
+	mediaType, params, parts := messageParts(msg)
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "feedback-report") {
+		return Complaint{}, false
+	}
+
+	var report, original mail.Header
+	for _, p := range parts {
+		switch p.mediaType {
+		case "message/feedback-report":
+			if blocks := parseHeaderBlocks(p.body); len(blocks) > 0 {
+				report = mail.Header(blocks[0])
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			original = embeddedHeaders(p.body)
+		}
+	}
+	if report == nil {
+		return Complaint{}, false
+	}
+
+	c := Complaint{FeedbackType: strings.ToLower(strings.TrimSpace(report.Get("Feedback-Type")))}
+	if c.FeedbackType == "" {
+		c.FeedbackType = "abuse"
+	}
+
+	// Providers redact the recipient more often than our own headers, so
+	// prefer the Message-ID and the VERP envelope sender we generated
+	var returnPath string
+	if original != nil {
+		c.MessageID = strings.TrimSpace(original.Get("Message-Id"))
+		returnPath = original.Get("Return-Path")
+	}
+	for _, sender := range []string{report.Get("Original-Mail-From"), returnPath} {
+		if recipient, ok := DecodeVERP(pattern, stripAddressType(sender)); ok {
+			c.Recipient = recipient
+			return c, true
+		}
+	}
+
+	c.Recipient = stripAddressType(report.Get("Original-Rcpt-To"))
+	if c.Recipient == "" && original != nil {
+		if addrs, err := original.AddressList("To"); err == nil && len(addrs) == 1 {
+			c.Recipient = addrs[0].Address
+		}
+	}
+	return c, true
+}
+
+// applyComplaint: Marks the complaining recipient and suppresses the address
+// permanently. Returns the number of records updated.
+func (a *App) applyComplaint(c Complaint) (int, error) {
+	match := BounceMatch{MessageID: c.MessageID, Email: c.Recipient}
+	count, email, err := MarkComplained(a.cfg.Database.Path, match, c.FeedbackType)
+	if err != nil || count == 0 {
+		return count, err
+	}
+	if err := AddSuppression(a.suppressionPath(), email, complaintReason+" "+c.FeedbackType); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// complaintRate: Complaints as a percentage of delivered messages
+func complaintRate(stats Stats) float64 {
+	if stats.Delivered == 0 {
+		return 0
+	}
+	return float64(stats.Complained) / float64(stats.Delivered) * 100
+}
+
+// checkComplaintRate: Stops the dispatcher while the complaint rate is above
+// complaints.max_rate_percent. Small samples are ignored until min_sent
+// messages have been delivered.
+func (a *App) checkComplaintRate(stats Stats) {
+	limit := a.cfg.Complaints.MaxRatePercent
+	if limit <= 0 {
+		return
+	}
+	minSent := a.cfg.Complaints.MinSent
+	if minSent <= 0 {
+		minSent = defaultComplaintMinSent
+	}
+	if stats.Delivered < minSent {
+		return
+	}
+	rate := complaintRate(stats)
+	if rate <= limit {
+		return
+	}
+
+	a.mu.Lock()
+	running := a.booted
+	a.booted = false
+	a.mu.Unlock()
+	if running {
+		a.addLog(fmt.Sprintf("Error: complaint rate %.2f%% is above %.2f%%, dispatcher STOPPED. Review the campaign before resuming.", rate, limit))
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import "testing"
+
+// arfMessage: Feedback report with the given report fields and headers of
+// the original message
+func arfMessage(report, original string) string {
+	return "From: fbl@provider.example\r\n" +
+		"Subject: Abuse report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/report; report-type=feedback-report; boundary=\"arf\"\r\n" +
+		"\r\n" +
+		"--arf\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"This is an email abuse report.\r\n" +
+		"--arf\r\n" +
+		"Content-Type: message/feedback-report\r\n" +
+		"\r\n" +
+		"Version: 1\r\n" +
+		report +
+		"--arf\r\n" +
+		"Content-Type: text/rfc822-headers\r\n" +
+		"\r\n" +
+		original +
+		"--arf--\r\n"
+}
+
+func TestParseComplaint(t *testing.T) {
+	const pattern = "bounces+{token}@sender.example"
+	tests := []struct {
+		name, report, original string
+		want                   Complaint
+	}{
+		{
+			name:     "VERP envelope sender",
+			report:   "Feedback-Type: abuse\r\nOriginal-Mail-From: <bounces+ann=example.com@sender.example>\r\nOriginal-Rcpt-To: redacted@provider.example\r\n",
+			original: "Message-ID: <m1@sender.example>\r\nTo: redacted@provider.example\r\n",
+			want:     Complaint{Recipient: "ann@example.com", MessageID: "<m1@sender.example>", FeedbackType: "abuse"},
+		},
+		{
+			name:     "VERP Return-Path of the original",
+			report:   "Feedback-Type: Fraud\r\n",
+			original: "Return-Path: <bounces+bob=example.com@sender.example>\r\nTo: x@provider.example\r\n",
+			want:     Complaint{Recipient: "bob@example.com", FeedbackType: "fraud"},
+		},
+		{
+			name:     "Original-Rcpt-To",
+			report:   "Original-Rcpt-To: carol@example.com\r\n",
+			original: "Message-ID: <m3@sender.example>\r\n",
+			want:     Complaint{Recipient: "carol@example.com", MessageID: "<m3@sender.example>", FeedbackType: "abuse"},
+		},
+		{
+			name:     "To of the original",
+			report:   "Feedback-Type: abuse\r\n",
+			original: "To: Dave <dave@example.com>\r\n",
+			want:     Complaint{Recipient: "dave@example.com", FeedbackType: "abuse"},
+		},
+	}
+	for _, tt := range tests {
+		c, ok := parseComplaint(readTestMessage(t, arfMessage(tt.report, tt.original)), pattern)
+		if !ok || c != tt.want {
+			t.Errorf("%s: parseComplaint = %+v, %v; want %+v", tt.name, c, ok, tt.want)
+		}
+	}
+
+	// A bounce is not a complaint
+	if c, ok := parseComplaint(readTestMessage(t, testDSN), pattern); ok {
+		t.Errorf("DSN parsed as complaint %+v", c)
+	}
+}
+
+func TestComplaintRate(t *testing.T) {
+	if rate := complaintRate(Stats{}); rate != 0 {
+		t.Errorf("complaintRate(no sends) = %v", rate)
+	}
+	if rate := complaintRate(Stats{Sent: 990, Delivered: 1000, Complained: 3}); rate != 0.3 {
+		t.Errorf("complaintRate = %v, want 0.3", rate)
+	}
+}
@@ -0,0 +1,246 @@
+// history.go: Import history and undoing an import batch
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultImportHistoryPath = "imports.txt"
+
+// ImportBatch is one committed import as recorded in the import history
+type ImportBatch struct {
+	Date    time.Time
+	ID      string
+	Source  string
+	Added   int
+	Invalid int
+	// Undone is when the import was undone, Removed how many records that
+	// took out of the database
+	Undone  time.Time
+	Removed int
+	// Pending counts the records of the batch that are still PENDING
+	Pending int
+}
+
+// String formats a history line in the database layout:
+// {ISO8601_DATE} ; {ID} ; {SOURCE} ; key=value...
+func (b ImportBatch) String() string {
+	line := b.Date.UTC().Format(time.RFC3339) + " ; " + b.ID + " ; " + strings.ReplaceAll(b.Source, ";", ",")
+	line += fmt.Sprintf(" ; added=%d ; invalid=%d", b.Added, b.Invalid)
+	if !b.Undone.IsZero() {
+		line += fmt.Sprintf(" ; undone=%s ; removed=%d", b.Undone.UTC().Format(time.RFC3339), b.Removed)
+	}
+	return line
+}
+
+// parseImportBatch parses a history line
+func parseImportBatch(line string) (ImportBatch, bool) {
+	parts := strings.Split(line, ";")
+	if len(parts) < 3 {
+		return ImportBatch{}, false
+	}
+	b := ImportBatch{ID: strings.TrimSpace(parts[1]), Source: strings.TrimSpace(parts[2])}
+	if b.ID == "" {
+		return ImportBatch{}, false
+	}
+	b.Date, _ = time.Parse(time.RFC3339, strings.TrimSpace(parts[0]))
+	for _, part := range parts[3:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "added":
+			b.Added, _ = strconv.Atoi(value)
+		case "invalid":
+			b.Invalid, _ = strconv.Atoi(value)
+		case "undone":
+			b.Undone, _ = time.Parse(time.RFC3339, value)
+		case "removed":
+			b.Removed, _ = strconv.Atoi(value)
+		}
+	}
+	return b, true
+}
+
+// importHistoryPath: Configured import history path or the default
+func (a *App) importHistoryPath() string {
+	if a.cfg.Import.HistoryPath != "" {
+		return a.cfg.Import.HistoryPath
+	}
+	return a.cfg.resolve(defaultImportHistoryPath)
+}
+
+// readImportHistory reads the history file, oldest first; a missing file is
+// empty. The caller holds dbMu.
+func readImportHistory(path string) ([]ImportBatch, error) {
+	lines, err := NewDatabase(path).readLines()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var batches []ImportBatch
+	for _, line := range lines {
+		if b, ok := parseImportBatch(line); ok {
+			batches = append(batches, b)
+		}
+	}
+	return batches, nil
+}
+
+// AppendImportBatch records a committed import in the history file
+func AppendImportBatch(path string, b ImportBatch) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(b.String() + "\n")
+	return err
+}
+
+// markImportUndone stores when an import was undone and how many records
+// were removed
+func markImportUndone(path, id string, at time.Time, removed int) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	batches, err := readImportHistory(path)
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0, len(batches))
+	for _, b := range batches {
+		if b.ID == id {
+			b.Undone, b.Removed = at, b.Removed+removed
+		}
+		lines = append(lines, b.String())
+	}
+	return NewDatabase(path).writeLines(lines)
+}
+
+// RemoveImport deletes the PENDING records of an import batch. Returns how
+// many were removed and how many records of the batch were kept: those
+// being or already sent, and those stored with another status such as
+// INVALID, which record a finding about the address.
+func RemoveImport(path, id string) (int, int, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	db := NewDatabase(path)
+	lines, err := db.readLines()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed, kept := 0, 0
+	remaining := lines[:0]
+	for _, line := range lines {
+		record, err := parseDBLine(line)
+		if err != nil || record.Field(FieldImportID) != id {
+			remaining = append(remaining, line)
+			continue
+		}
+		if record.Status == StatusPending {
+			removed++
+			continue
+		}
+		kept++
+		remaining = append(remaining, line)
+	}
+	if removed == 0 {
+		return 0, kept, nil
+	}
+	return removed, kept, db.writeLines(remaining)
+}
+
+// ImportHistory returns the recorded imports, newest first, with the number
+// of their records still pending
+func (a *App) ImportHistory() ([]ImportBatch, error) {
+	dbMu.Lock()
+	batches, err := readImportHistory(a.importHistoryPath())
+	dbMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]int)
+	err = NewDatabase(a.cfg.Database.Path).forEach(func(r *dbRecord, _ int) error {
+		if id := r.Field(FieldImportID); id != "" && r.Status == StatusPending {
+			pending[id]++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for i, j := 0, len(batches)-1; i < j; i, j = i+1, j-1 {
+		batches[i], batches[j] = batches[j], batches[i]
+	}
+	for i := range batches {
+		batches[i].Pending = pending[batches[i].ID]
+	}
+	return batches, nil
+}
+
+// UndoImport removes the unsent records of an import batch
+func (a *App) UndoImport(b ImportBatch) error {
+	removed, kept, err := RemoveImport(a.cfg.Database.Path, b.ID)
+	if err != nil {
+		return err
+	}
+	if err := markImportUndone(a.importHistoryPath(), b.ID, time.Now(), removed); err != nil {
+		a.addLog(fmt.Sprintf("Error updating %s: %v", a.importHistoryPath(), err))
+	}
+
+	msg := fmt.Sprintf("Import %s of %s undone: %d records removed", b.ID, b.Source, removed)
+	if kept > 0 {
+		msg += fmt.Sprintf(", %d records that were not pending kept", kept)
+	}
+	a.addLog(msg)
+	a.updateStats()
+	return nil
+}
+
+const maxHistoryEntries = 50
+
+// importHistoryContent: Renders the import history for the Import screen
+func importHistoryContent(batches []ImportBatch, selected int, confirm bool) string {
+	content := "Import history\n\n"
+	if len(batches) == 0 {
+		content += "No imports yet\n"
+	}
+	for i, b := range batches {
+		if i == maxHistoryEntries {
+			content += fmt.Sprintf("... and %d older imports\n", len(batches)-maxHistoryEntries)
+			break
+		}
+		prefix := "  "
+		if i == selected {
+			prefix = "> "
+		}
+		state := fmt.Sprintf("%d pending", b.Pending)
+		if !b.Undone.IsZero() {
+			state = fmt.Sprintf("undone %s, %d removed", b.Undone.Local().Format("2006-01-02 15:04"), b.Removed)
+		}
+		content += fmt.Sprintf("%s%s  %-20s %-30s %5d added  %s\n",
+			prefix, b.Date.Local().Format("2006-01-02 15:04"), b.ID, b.Source, b.Added, state)
+	}
+
+	if confirm && selected >= 0 && selected < len(batches) {
+		b := batches[selected]
+		content += fmt.Sprintf("\nUndo import %s of %s? This removes its %d pending records. y to undo, n to cancel", b.ID, b.Source, b.Pending)
+	} else {
+		content += "\nUp/Down to select, d to undo the selected import, Esc back to the files"
+	}
+	return content
+}
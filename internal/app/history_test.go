@@ -0,0 +1,34 @@
+package app
+
+import "testing"
+
+func TestRemoveImportKeepsNonPending(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; PENDING ; new@example.com ; ; import_id=b1",
+		"2026-01-01T00:00:00Z ; INVALID ; bad@example ; no domain ; import_id=b1",
+		"2026-01-01T00:00:00Z ; DONE ; sent@example.com ; ; import_id=b1",
+		"2026-01-01T00:00:00Z ; PENDING ; other@example.com ; ; import_id=b2",
+	)
+	removed, kept, err := RemoveImport(a.cfg.Database.Path, "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || kept != 2 {
+		t.Errorf("RemoveImport = %d removed, %d kept; want 1, 2", removed, kept)
+	}
+
+	var emails []string
+	for _, r := range readTestRecords(t, a) {
+		emails = append(emails, r.Email)
+	}
+	want := []string{"bad@example", "sent@example.com", "other@example.com"}
+	if len(emails) != len(want) {
+		t.Fatalf("records %v, want %v", emails, want)
+	}
+	for i := range want {
+		if emails[i] != want[i] {
+			t.Errorf("records %v, want %v", emails, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,401 @@
+// importer.go: Shared import pipeline for recipient sources
+
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var fieldNameRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// systemFields are record fields maintained by the app itself; imports must
+// not overwrite them
+var systemFields = map[string]bool{
+	FieldClicks:    true,
+	FieldFirstOpen: true,
+	FieldOpens:     true,
+	FieldBounce:    true,
+	FieldMessageID: true,
+	FieldComplaint: true,
+	FieldImportID:  true,
+	FieldImported:  true,
+	FieldWarning:   true,
+	FieldVariant:   true,
+	FieldSentAt:    true,
+}
+
+// ImportRow is one recipient read from an import source
+type ImportRow struct {
+	Email  string
+	Fields map[string]string
+	// Invalid is the reason the address failed validation, if it did
+	Invalid string
+	// Warning notes a doubt about a valid address, such as a possible typo
+	// in the domain. The address is imported and the warning reported.
+	Warning string
+}
+
+// normalizeFieldName: "First Name" -> "first_name", "Language" -> "lang"
+func normalizeFieldName(name string) string {
+	name = strings.Trim(fieldNameRegex.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "_"), "_")
+	switch name {
+	case "language", "locale":
+		return FieldLang
+	}
+	return name
+}
+
+// validFieldName: Custom fields may use any normalised name that is not
+// maintained by the app or taken by the address itself
+func validFieldName(name string) bool {
+	return name != "" && name != columnEmail && name == normalizeFieldName(name) && !systemFields[name]
+}
+
+// rowSource streams the rows of an import source to fn and returns the
+// number of source rows without an address. A source is read once for the
+// preview and again on commit, so no import holds all its rows in memory.
+type rowSource func(p *ImportProgress, fn func(ImportRow) error) (int, error)
+
+// importChunkSize is the number of rows validated and written at a time
+const importChunkSize = 1000
+
+// ImportPlan is an import that has been read, normalised and validated but
+// not written yet, so it can be previewed before it is committed
+type ImportPlan struct {
+	ID     string
+	Source string
+	// Found counts the addresses read, Skipped the source rows without one
+	Found   int
+	Skipped int
+	// Expected is the outcome if committed now
+	Expected ImportResult
+	// Preview holds one "address  note" line for each of the first
+	// maxPreviewLines addresses read
+	Preview []string
+
+	read      rowSource
+	validator *Validator
+	// path is the file read, stamp its state when the plan was made
+	path  string
+	stamp sourceStamp
+}
+
+// errSourceChanged: The file of an import changed between preview and
+// commit, so the commit would not write what was previewed
+var errSourceChanged = errors.New("changed since the preview, import it again")
+
+// sourceStamp identifies the content of a file by size and modification
+// time
+type sourceStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// stampSource: Size and modification time of a file
+func stampSource(path string) (sourceStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sourceStamp{}, err
+	}
+	return sourceStamp{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// newImportID: Identifies an import in the log, e.g. 20260103-103000-4f2a
+func newImportID() (string, error) {
+	random := make([]byte, 2)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate import ID: %v", err)
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(random), nil
+}
+
+// streamImport: Reads a source in chunks of importChunkSize rows,
+// normalising the addresses, leaving out suppressed and unstorable ones and
+// validating the rest. fn is called per chunk with the rows to store and
+// with the dropped rows counted in a result and a preview line each.
+// Invalid addresses are kept and stored as INVALID so they are reported
+// once and not imported again.
+func (a *App) streamImport(read rowSource, validator *Validator, p *ImportProgress, fn func(rows []ImportRow, dropped ImportResult, droppedNotes []string) error) (int, int, error) {
+	suppressions, err := LoadSuppressions(a.suppressionPath())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var rows []ImportRow
+	var dropped ImportResult
+	var droppedNotes []string
+	flush := func() error {
+		emails := make([]string, 0, len(rows))
+		for _, row := range rows {
+			emails = append(emails, row.Email)
+		}
+		validator.Prefetch(emails)
+		for i := range rows {
+			if rows[i].Invalid == "" {
+				rows[i].Invalid = validator.Check(rows[i].Email)
+			}
+			if rows[i].Invalid == "" {
+				rows[i].Warning = validator.Suggest(rows[i].Email)
+			}
+		}
+		err := fn(rows, dropped, droppedNotes)
+		rows, dropped, droppedNotes = rows[:0], ImportResult{}, nil
+		return err
+	}
+
+	found := 0
+	p.rows.Store(0)
+	skipped, err := read(p, func(row ImportRow) error {
+		if p.Cancelled() {
+			return errImportCancelled
+		}
+		found++
+		p.rows.Add(1)
+
+		email, err := normalizeAddress(row.Email)
+		if err != nil {
+			if strings.ContainsAny(row.Email, "; \t\r\n") {
+				// Cannot even be stored in a record
+				dropped.addInvalid(row.Email, err.Error())
+				droppedNotes = append(droppedNotes, formatPreviewLine(row, "invalid: "+err.Error()))
+				return nil
+			}
+			email, row.Invalid = row.Email, err.Error()
+		}
+		row.Email = email
+		if entry, ok := suppressions.Match(row.Email); ok {
+			dropped.Suppressed++
+			droppedNotes = append(droppedNotes, formatPreviewLine(row, "suppressed by "+entry.Entry))
+			return nil
+		}
+		if lang, ok := row.Fields[FieldLang]; ok {
+			row.Fields[FieldLang] = normalizeLang(lang)
+		}
+		rows = append(rows, row)
+		if len(rows)+len(droppedNotes) >= importChunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return found, skipped, err
+}
+
+// planImport: Reads a source and works out what committing it would do,
+// without writing anything. path is the file the source reads. Waits for
+// imports being planned or committed meanwhile.
+func (a *App) planImport(source, path string, read rowSource, p *ImportProgress) (*ImportPlan, error) {
+	unlock, err := a.lockImport()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	stamp, err := stampSource(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := LoadRecipientKeys(a.cfg.Database.Path, a.addressKeyFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newImportID()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ImportPlan{ID: id, Source: source, read: read, validator: a.newValidator(), path: path, stamp: stamp}
+	addPreview := func(line string) {
+		if len(plan.Preview) < maxPreviewLines {
+			plan.Preview = append(plan.Preview, line)
+		}
+	}
+	plan.Found, plan.Skipped, err = a.streamImport(read, plan.validator, p, func(rows []ImportRow, dropped ImportResult, droppedNotes []string) error {
+		expected, notes := keys.Preview(rows)
+		plan.Expected.add(expected)
+		plan.Expected.add(dropped)
+		for i, row := range rows {
+			addPreview(formatPreviewLine(row, notes[i]))
+		}
+		for _, note := range droppedNotes {
+			addPreview(note)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planFile: Plans the import of a file by its type, without a preview step.
+// CSV/TSV files and the result of query on a SQLite file use the guessed
+// column mapping.
+func (a *App) planFile(path, query string, p *ImportProgress) (*ImportPlan, error) {
+	if !isImportFile(path, "") {
+		return nil, fmt.Errorf("unsupported file type %q", filepath.Ext(path))
+	}
+	if isCSVFile(path) {
+		c, err := OpenCSVImport(path)
+		if err != nil {
+			return nil, err
+		}
+		return a.PlanCSVImport(c, p)
+	}
+	if isSQLiteFile(path) {
+		if query == "" {
+			return nil, errors.New("set import.sqlite_query to import SQLite files")
+		}
+		c, err := a.OpenSQLiteImport(path, query)
+		if err != nil {
+			return nil, err
+		}
+		return a.PlanCSVImport(c, p)
+	}
+	return a.PlanFileImport(path, p)
+}
+
+func formatPreviewLine(row ImportRow, note string) string {
+	line := fmt.Sprintf("%-40s %s", row.Email, note)
+	if name := row.Fields[FieldName]; name != "" {
+		line += "  (" + name + ")"
+	}
+	return line
+}
+
+// CommitImport reads the source of a planned import again and writes it to
+// the database chunk by chunk, tagging every record with the import ID and
+// time so the batch can be undone, and records it in the import history.
+// When cancelled or failing midway, the records written so far are kept
+// and recorded, so they can be undone like a complete import. A source
+// file changed since the plan was made is not imported. Imports of this or
+// another process wait for each other.
+func (a *App) CommitImport(plan *ImportPlan, p *ImportProgress) (ImportResult, error) {
+	unlock, err := a.lockImport()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer unlock()
+
+	if stamp, err := stampSource(plan.path); err != nil {
+		return ImportResult{}, err
+	} else if stamp != plan.stamp {
+		return ImportResult{}, fmt.Errorf("%s %w", plan.path, errSourceChanged)
+	}
+	keys, err := LoadRecipientKeys(a.cfg.Database.Path, a.addressKeyFunc())
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	a.committing.Add(1)
+	defer a.endCommit()
+
+	now := time.Now()
+	var result ImportResult
+	plan.Found, plan.Skipped, err = a.streamImport(plan.read, plan.validator, p, func(rows []ImportRow, dropped ImportResult, _ []string) error {
+		for i := range rows {
+			if rows[i].Fields == nil {
+				rows[i].Fields = make(map[string]string)
+			}
+			rows[i].Fields[FieldImportID] = plan.ID
+			rows[i].Fields[FieldImported] = now.UTC().Format(time.RFC3339)
+		}
+		added, err := AddRecipients(a.cfg.Database.Path, rows, keys)
+		result.add(added)
+		result.add(dropped)
+		return err
+	})
+	if err != nil && result.Added+result.Invalid == 0 {
+		return result, err
+	}
+
+	batch := ImportBatch{Date: now, ID: plan.ID, Source: plan.Source, Added: result.Added, Invalid: result.Invalid}
+	if err := AppendImportBatch(a.importHistoryPath(), batch); err != nil {
+		a.addLog(fmt.Sprintf("Error updating %s: %v", a.importHistoryPath(), err))
+	}
+	a.logImportResult(plan, result)
+	if err != nil {
+		a.addLog(fmt.Sprintf("Import %s stopped after reading %d addresses; undo it from the import history to remove the records written", plan.ID, plan.Found))
+	}
+	a.updateStats()
+	a.addLog(fmt.Sprintf("New pending count: %d", a.stats.Pending))
+	return result, err
+}
+
+// endCommit: Converts the lines added by hand while the last running
+// import was writing, whose change events were skipped
+func (a *App) endCommit() {
+	if a.committing.Add(-1) > 0 || !a.missedChange.Swap(false) {
+		return
+	}
+	if err := a.UpdateDataFile(a.cfg.Database.Path); err != nil {
+		a.addLog(fmt.Sprintf("UpdateDataFile error: %v", err))
+	}
+	a.updateStats()
+}
+
+// logImportResult: Logs the summary and duplicate report of an import
+func (a *App) logImportResult(plan *ImportPlan, r ImportResult) {
+	found := fmt.Sprintf("%d addresses", plan.Found)
+	if plan.Skipped > 0 {
+		found += fmt.Sprintf(", %d rows without one", plan.Skipped)
+	}
+	a.addLog(fmt.Sprintf("Import %s from %s (%s): %d added, %d duplicates in file, %d already in database, %d suppressed, %d invalid, %d possible typos",
+		plan.ID, plan.Source, found, r.Added, r.FileDuplicates, r.DBDuplicates, r.Suppressed, r.Invalid, r.Warned))
+	for _, d := range r.Duplicates {
+		a.addLog("  duplicate: " + d)
+	}
+	if more := r.FileDuplicates + r.DBDuplicates - len(r.Duplicates); more > 0 {
+		a.addLog(fmt.Sprintf("  ... and %d more duplicates", more))
+	}
+	for _, invalid := range r.Invalids {
+		a.addLog("  invalid: " + invalid)
+	}
+	if more := r.Invalid - len(r.Invalids); more > 0 {
+		a.addLog(fmt.Sprintf("  ... and %d more invalid", more))
+	}
+	for _, warning := range r.Warnings {
+		a.addLog("  warning: " + warning)
+	}
+	if more := r.Warned - len(r.Warnings); more > 0 {
+		a.addLog(fmt.Sprintf("  ... and %d more warnings", more))
+	}
+}
+
+const maxPreviewLines = 500
+
+// importPreviewContent: Renders a planned import for confirmation
+func importPreviewContent(plan *ImportPlan) string {
+	r := plan.Expected
+	content := fmt.Sprintf("Import preview for %s (import %s)\n\n", plan.Source, plan.ID)
+	content += fmt.Sprintf("Addresses found:     %6d", plan.Found)
+	if plan.Skipped > 0 {
+		content += fmt.Sprintf("  (%d rows without an address)", plan.Skipped)
+	}
+	content += fmt.Sprintf("\nNew:                 %6d\n", r.Added)
+	content += fmt.Sprintf("Duplicates in file:  %6d\n", r.FileDuplicates)
+	content += fmt.Sprintf("Already in database: %6d\n", r.DBDuplicates)
+	content += fmt.Sprintf("Suppressed:          %6d\n", r.Suppressed)
+	content += fmt.Sprintf("Invalid:             %6d  (stored as INVALID)\n", r.Invalid)
+	content += fmt.Sprintf("Possible typos:      %6d  (imported, see the warnings)\n", r.Warned)
+	content += "\ny import, n cancel, Up/Down to scroll\n\n"
+
+	for _, line := range plan.Preview {
+		content += line + "\n"
+	}
+	if more := plan.Found - len(plan.Preview); more > 0 {
+		content += fmt.Sprintf("... and %d more\n", more)
+	}
+	return content
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestApp: App whose config, database and side files live in a temporary
+// directory
+func newTestApp(t *testing.T, records ...string) *App {
+	t.Helper()
+	dir := t.TempDir()
+	a := &App{cfg: &Config{dir: dir}}
+	a.cfg.Database.Path = filepath.Join(dir, "data.txt")
+	data := ""
+	for _, r := range records {
+		data += r + "\n"
+	}
+	if err := os.WriteFile(a.cfg.Database.Path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// writeTestFile: Writes a file into the directory of the test app
+func writeTestFile(t *testing.T, a *App, name, content string) string {
+	t.Helper()
+	path := filepath.Join(a.cfg.dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestRecords: Parses every record of the test database
+func readTestRecords(t *testing.T, a *App) []*dbRecord {
+	t.Helper()
+	lines, err := NewDatabase(a.cfg.Database.Path).readLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*dbRecord
+	for _, line := range lines {
+		if record, err := parseDBLine(line); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestCommitImportStreamsChunks(t *testing.T) {
+	a := newTestApp(t, "2026-01-01T00:00:00Z ; PENDING ; u5@example.com")
+	var list strings.Builder
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&list, "u%d@example.com\n", i%2000)
+	}
+	path := writeTestFile(t, a, "list.txt", list.String())
+
+	p := &ImportProgress{}
+	plan, err := a.PlanFileImport(path, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Found != 2500 || plan.Expected.Added != 1999 || plan.Expected.FileDuplicates != 499 || plan.Expected.DBDuplicates != 2 {
+		t.Fatalf("plan: found %d, expected %+v", plan.Found, plan.Expected)
+	}
+	if len(plan.Preview) != maxPreviewLines {
+		t.Errorf("preview has %d lines, want %d", len(plan.Preview), maxPreviewLines)
+	}
+	if p.read.Load() != p.total.Load() || p.rows.Load() != 2500 {
+		t.Errorf("progress read %d of %d, %d rows", p.read.Load(), p.total.Load(), p.rows.Load())
+	}
+
+	result, err := a.CommitImport(plan, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 1999 {
+		t.Errorf("added %d, want 1999", result.Added)
+	}
+	records := readTestRecords(t, a)
+	if len(records) != 2000 {
+		t.Fatalf("database has %d records, want 2000", len(records))
+	}
+	if id := records[1].Field(FieldImportID); id != plan.ID {
+		t.Errorf("record tagged with import %q, want %q", id, plan.ID)
+	}
+
+	history, err := a.ImportHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].ID != plan.ID || history[0].Added != 1999 {
+		t.Errorf("history %+v", history)
+	}
+}
+
+func TestCommitImportCancelKeepsWrittenChunks(t *testing.T) {
+	a := newTestApp(t)
+	var list strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&list, "c%d@example.com\n", i)
+	}
+	path := writeTestFile(t, a, "list.txt", list.String())
+
+	plan, err := a.PlanFileImport(path, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ImportProgress{}
+	read, rows := plan.read, 0
+	plan.read = func(p *ImportProgress, fn func(ImportRow) error) (int, error) {
+		return read(p, func(row ImportRow) error {
+			if rows++; rows == 1500 {
+				p.Cancel()
+			}
+			return fn(row)
+		})
+	}
+
+	result, err := a.CommitImport(plan, p)
+	if !errors.Is(err, errImportCancelled) {
+		t.Fatalf("err = %v, want cancelled", err)
+	}
+	if result.Added != importChunkSize || len(readTestRecords(t, a)) != importChunkSize {
+		t.Errorf("added %d, want the first chunk of %d", result.Added, importChunkSize)
+	}
+	history, err := a.ImportHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Added != importChunkSize {
+		t.Errorf("history %+v, want the partial batch", history)
+	}
+}
+
+func TestPlanCSVImportKeepsMapping(t *testing.T) {
+	a := newTestApp(t)
+	path := writeTestFile(t, a, "list.csv", "\xEF\xBB\xBFname,email\nBob,bob@example.com\nX,nope\nAl,al@example.com\n")
+
+	c, err := OpenCSVImport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := a.PlanCSVImport(c, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Found != 2 || plan.Skipped != 1 || plan.Expected.Added != 2 {
+		t.Fatalf("plan: found %d, expected %+v", plan.Found, plan.Expected)
+	}
+
+	// Editing the mapping after the preview must not change the commit
+	c.Mapping[0] = ""
+	if _, err := a.CommitImport(plan, &ImportProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	records := readTestRecords(t, a)
+	if len(records) != 2 || records[0].Field(FieldName) != "Bob" {
+		t.Errorf("records %+v", records)
+	}
+}
+
+func TestImportKeepsPossibleTyposPending(t *testing.T) {
+	a := newTestApp(t)
+	path := writeTestFile(t, a, "list.txt", "bob@gmial.com\nann@yahoo.co.jp\n")
+
+	plan, err := a.PlanFileImport(path, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Expected.Added != 2 || plan.Expected.Invalid != 0 || plan.Expected.Warned != 1 {
+		t.Fatalf("expected %+v", plan.Expected)
+	}
+	result, err := a.CommitImport(plan, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || !strings.HasPrefix(result.Warnings[0], "bob@gmial.com: possible typo") {
+		t.Errorf("warnings %v", result.Warnings)
+	}
+	for _, record := range readTestRecords(t, a) {
+		if record.Status != StatusPending {
+			t.Errorf("%s stored as %s", record.Email, record.Status)
+		}
+		warned := record.Field(FieldWarning) != ""
+		if warned != (record.Email == "bob@gmial.com") {
+			t.Errorf("%s has warning %q", record.Email, record.Field(FieldWarning))
+		}
+	}
+}
+
+func TestCommitImportRejectsChangedSource(t *testing.T) {
+	a := newTestApp(t)
+	path := writeTestFile(t, a, "list.txt", "bob@example.com\n")
+	plan, err := a.PlanFileImport(path, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, a, "list.txt", "bob@example.com\neve@example.com\n")
+	if _, err := a.CommitImport(plan, &ImportProgress{}); !errors.Is(err, errSourceChanged) {
+		t.Fatalf("err = %v, want changed source", err)
+	}
+	if records := readTestRecords(t, a); len(records) != 0 {
+		t.Errorf("wrote %d records", len(records))
+	}
+}
+
+func TestCommitImportSkipsChangeEvents(t *testing.T) {
+	a := newTestApp(t)
+	path := writeTestFile(t, a, "list.txt", "bob@example.com\n")
+	plan, err := a.PlanFileImport(path, &ImportProgress{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A line typed into the database while the import writes is converted
+	// once the import is done
+	read := plan.read
+	plan.read = func(p *ImportProgress, fn func(ImportRow) error) (int, error) {
+		if a.committing.Load() != 1 {
+			t.Error("import not marked as committing")
+		}
+		f, err := os.OpenFile(a.cfg.Database.Path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return 0, err
+		}
+		f.WriteString("ann@example.com\n")
+		f.Close()
+		a.missedChange.Store(true)
+		return read(p, fn)
+	}
+	if _, err := a.CommitImport(plan, &ImportProgress{}); err != nil {
+		t.Fatal(err)
+	}
+	records := readTestRecords(t, a)
+	if len(records) != 2 || records[0].Email != "ann@example.com" || records[0].Status != StatusPending {
+		t.Errorf("records %+v", records)
+	}
+	if a.committing.Load() != 0 || a.missedChange.Load() {
+		t.Error("commit state not reset")
+	}
+}
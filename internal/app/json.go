@@ -0,0 +1,269 @@
+// json.go: Recipient import from JSON arrays and newline-delimited JSON
+
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isJSONFile: .json, .ndjson and .jsonl files are read as JSON
+func isJSONFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".ndjson", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// splitJSONPath: "contact.emails.0" -> [contact emails 0]
+func splitJSONPath(path string) []string {
+	var parts []string
+	for _, p := range strings.Split(path, ".") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// readJSONRecipients: Reads a JSON array or a stream of JSON values (NDJSON)
+// of recipient objects, one value at a time. The address is taken from
+// emailPath (dot-separated keys and array indexes) or, when empty, from a
+// top-level key such as "email". Every other scalar value becomes a custom
+// field named after its key path, e.g. {"company": {"name": "Acme"}} ->
+// company_name=Acme. Array elements that are plain strings are read as
+// addresses. Returns the number of values without an address.
+func readJSONRecipients(r io.Reader, emailPath string, fn func(ImportRow) error) (int, error) {
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		buffered.Discard(3)
+	}
+	decoder := json.NewDecoder(buffered)
+	decoder.UseNumber()
+
+	skipped := 0
+	add := func(value any) error {
+		if row, ok := jsonRow(value, splitJSONPath(emailPath)); ok {
+			return fn(row)
+		}
+		skipped++
+		return nil
+	}
+
+	first, err := firstNonSpace(buffered)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, errors.New("file is empty")
+		}
+		return 0, err
+	}
+
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return 0, err
+		}
+		for decoder.More() {
+			var value any
+			if err := decoder.Decode(&value); err != nil {
+				return skipped, jsonError(decoder, err)
+			}
+			if err := add(value); err != nil {
+				return skipped, err
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return skipped, jsonError(decoder, err)
+		}
+		return skipped, nil
+	}
+
+	for {
+		var value any
+		err := decoder.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return skipped, jsonError(decoder, err)
+		}
+		if err := add(value); err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// firstNonSpace: Peeks at the first non-whitespace byte
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		data, err := r.Peek(n)
+		if len(data) < n {
+			return 0, err
+		}
+		switch c := data[n-1]; c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return c, nil
+		}
+	}
+}
+
+// jsonError: Adds the byte offset to a decoding error
+func jsonError(decoder *json.Decoder, err error) error {
+	return fmt.Errorf("invalid JSON near byte %d: %w", decoder.InputOffset(), err)
+}
+
+// jsonRow: Builds the import row of one JSON value
+func jsonRow(value any, emailPath []string) (ImportRow, bool) {
+	if s, ok := value.(string); ok {
+		if email := cleanAddress(s); emailValueRegex.MatchString(email) {
+			return ImportRow{Email: email, Fields: make(map[string]string)}, true
+		}
+		return ImportRow{}, false
+	}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return ImportRow{}, false
+	}
+
+	if len(emailPath) == 0 {
+		emailPath = guessJSONEmailKey(object)
+	}
+	email, ok := lookupJSONPath(object, emailPath).(string)
+	if !ok || !emailValueRegex.MatchString(cleanAddress(email)) {
+		return ImportRow{}, false
+	}
+
+	row := ImportRow{Email: cleanAddress(email), Fields: make(map[string]string)}
+	// An address in an array (contact.emails.0) is flattened with the rest
+	// of the array under the array's key path (contact.emails)
+	container := emailPath
+	for len(container) > 1 && isJSONIndex(container[len(container)-1]) {
+		container = container[:len(container)-1]
+	}
+	skip := strings.Join(container, ".")
+	// Keys next to a nested address describe the recipient too:
+	// contact.lang -> lang for the path contact.email
+	parent := strings.Join(container[:len(container)-1], ".")
+	flattenJSON("", object, func(key, value string) {
+		if key == skip {
+			return
+		}
+		if parent != "" && strings.HasPrefix(key, parent+".") {
+			key = key[len(parent)+1:]
+		}
+		if name := normalizeFieldName(key); validFieldName(name) && row.Fields[name] == "" {
+			row.Fields[name] = value
+		}
+	})
+	return row, true
+}
+
+// isJSONIndex: The key path part indexes an array
+func isJSONIndex(part string) bool {
+	_, err := strconv.Atoi(part)
+	return err == nil
+}
+
+// guessJSONEmailKey: First top-level key named like an address column
+// whose value is an address
+func guessJSONEmailKey(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !emailHeaderNames[normalizeFieldName(key)] {
+			continue
+		}
+		if s, ok := object[key].(string); ok && emailValueRegex.MatchString(cleanAddress(s)) {
+			return []string{key}
+		}
+	}
+	return nil
+}
+
+// lookupJSONPath: Value at a key path; numeric parts index arrays
+func lookupJSONPath(value any, path []string) any {
+	if len(path) == 0 {
+		return nil
+	}
+	for _, part := range path {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[part]
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// flattenJSON: Calls fn with the dotted key path and text of every scalar in
+// a JSON value, in key order. Arrays of scalars are joined with ", ".
+func flattenJSON(prefix string, value any, fn func(key, value string)) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			flattenJSON(join(key), v[key], fn)
+		}
+	case []any:
+		var scalars []string
+		for i, item := range v {
+			if text, ok := jsonScalar(item); ok {
+				scalars = append(scalars, text)
+			} else {
+				flattenJSON(join(strconv.Itoa(i)), item, fn)
+			}
+		}
+		if len(scalars) > 0 {
+			fn(prefix, strings.Join(scalars, ", "))
+		}
+	default:
+		if text, ok := jsonScalar(v); ok && text != "" {
+			fn(prefix, text)
+		}
+	}
+}
+
+// jsonScalar: Text of a string, number or boolean; false for null,
+// objects and arrays
+func jsonScalar(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v), true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readTestJSON: Rows of a JSON source as "email field=value ..." strings
+func readTestJSON(t *testing.T, data, emailPath string) ([]string, int, error) {
+	t.Helper()
+	var rows []string
+	skipped, err := readJSONRecipients(strings.NewReader(data), emailPath, func(row ImportRow) error {
+		text := row.Email
+		for _, key := range sortedKeys(row.Fields) {
+			text += " " + key + "=" + row.Fields[key]
+		}
+		rows = append(rows, text)
+		return nil
+	})
+	return rows, skipped, err
+}
+
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestReadJSONRecipients(t *testing.T) {
+	tests := []struct {
+		name, data, path string
+		want             []string
+		skipped          int
+	}{
+		{
+			name: "array",
+			data: "\xEF\xBB\xBF" + `[
+				{"Email": "<ann@example.com>", "company": {"name": "Acme"}, "tags": ["a", "b"], "age": 42, "vip": true, "note": null},
+				"bob@example.com",
+				{"name": "No Address"},
+				42
+			]`,
+			want:    []string{"ann@example.com age=42 company_name=Acme tags=a, b vip=true", "bob@example.com"},
+			skipped: 2,
+		},
+		{
+			name: "ndjson with path",
+			data: `{"contact": {"emails": ["carol@example.com"], "lang": "tr"}, "id": 7}
+{"contact": {"emails": []}}
+`,
+			path:    "contact.emails.0",
+			want:    []string{"carol@example.com id=7 lang=tr"},
+			skipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		rows, skipped, err := readTestJSON(t, tt.data, tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if strings.Join(rows, "\n") != strings.Join(tt.want, "\n") || skipped != tt.skipped {
+			t.Errorf("%s: rows %q skipped %d, want %q skipped %d", tt.name, rows, skipped, tt.want, tt.skipped)
+		}
+	}
+}
+
+func TestReadJSONRecipientsErrors(t *testing.T) {
+	if _, _, err := readTestJSON(t, "  \n", ""); err == nil || err.Error() != "file is empty" {
+		t.Errorf("empty file: %v", err)
+	}
+	rows, _, err := readTestJSON(t, `[{"email": "a@example.com"}, {"email": ]`, "")
+	if err == nil || !strings.Contains(err.Error(), "near byte") {
+		t.Errorf("broken array: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("rows before the error: %q", rows)
+	}
+}
@@ -0,0 +1,107 @@
+// lang.go: Per-recipient language selection for templates and subjects
+
+package app
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var langCodeRegex = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// normalizeLang: "TR", "pt_BR " -> "tr", "pt-br"
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+// localizedTemplatePath: mail.html + tr -> mail.tr.html
+func localizedTemplatePath(path, lang string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + lang + ext
+}
+
+// discoverLanguages: Finds the languages a template has siblings for,
+// e.g. mail.tr.html and mail.en.html next to mail.html
+func discoverLanguages(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "."
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	var langs []string
+	for _, m := range matches {
+		lang := normalizeLang(strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext))
+		if langCodeRegex.MatchString(lang) {
+			langs = append(langs, lang)
+		}
+	}
+	return langs, nil
+}
+
+func templateKey(variant, lang string) string {
+	return variant + "|" + lang
+}
+
+// loadLocalized: Loads a template and all of its language siblings into
+// a.templates under the given variant name
+func (a *App) loadLocalized(variant, path string) error {
+	body, err := a.loadTemplate(path)
+	if err != nil {
+		return err
+	}
+	a.templates[templateKey(variant, "")] = body
+	a.addLinks(body)
+
+	langs, err := discoverLanguages(path)
+	if err != nil {
+		return err
+	}
+	for _, lang := range langs {
+		body, err := a.loadTemplate(localizedTemplatePath(path, lang))
+		if err != nil {
+			return err
+		}
+		a.templates[templateKey(variant, lang)] = body
+		a.languages[lang] = true
+		a.addLinks(body)
+	}
+	return nil
+}
+
+// recipientLang: Resolves the language to use for a recipient, falling back
+// from pt-br to pt and then to the default language
+func (a *App) recipientLang(r *Recipient) string {
+	lang := normalizeLang(r.Fields[FieldLang])
+	if lang != "" {
+		if a.languages[lang] {
+			return lang
+		}
+		if base, _, ok := strings.Cut(lang, "-"); ok && a.languages[base] {
+			return base
+		}
+	}
+	return normalizeLang(a.cfg.Mail.DefaultLang)
+}
+
+// sortedLanguages: Returns the known languages in a stable order
+func (a *App) sortedLanguages() []string {
+	langs := make([]string, 0, len(a.languages))
+	for lang := range a.languages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
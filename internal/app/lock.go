@@ -0,0 +1,65 @@
+// lock.go: Lock files that keep two processes from sending from the same
+// database, and imports from running at the same time
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var errDatabaseLocked = errors.New("is in use by another bulkmail process (the terminal UI or send)")
+
+// lockPath: Hidden lock file next to the database, so a drop folder in the
+// same directory does not import it
+func lockPath(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "."+filepath.Base(dbPath)+".lock")
+}
+
+// lockDatabase: Takes the lock of the database for the life of the app; it
+// is released by Close, or by the system when the process exits
+func (a *App) lockDatabase() error {
+	path := a.cfg.Database.Path
+	file, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create lock file: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errDatabaseLocked) {
+			return fmt.Errorf("database %s %w", path, err)
+		}
+		return fmt.Errorf("failed to lock %s: %v", file.Name(), err)
+	}
+	a.dbLock = file
+	return nil
+}
+
+// importLockPath: Hidden lock file next to the database taken by imports
+func importLockPath(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "."+filepath.Base(dbPath)+".import.lock")
+}
+
+// lockImport: Waits until no other import of this process (the Import
+// screen, the drop folder) or of another one (the import command) plans or
+// commits into the database, and takes the lock. The returned func
+// releases it.
+func (a *App) lockImport() (func(), error) {
+	a.importMu.Lock()
+	file, err := os.OpenFile(importLockPath(a.cfg.Database.Path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		a.importMu.Unlock()
+		return nil, fmt.Errorf("failed to create import lock file: %v", err)
+	}
+	if err := waitLockFile(file); err != nil {
+		file.Close()
+		a.importMu.Unlock()
+		return nil, fmt.Errorf("failed to lock %s: %v", file.Name(), err)
+	}
+	return func() {
+		file.Close()
+		a.importMu.Unlock()
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package app
+
+import "os"
+
+// lockFile: Other systems run without the lock
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// waitLockFile: Other systems run without the lock
+func waitLockFile(file *os.File) error {
+	return nil
+}
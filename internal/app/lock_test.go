@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newCLITestApp: App on the sample config, template and database in a
+// temporary directory, with extra appended to the config
+func newCLITestApp(t *testing.T, extra string) *App {
+	t.Helper()
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	if err := CreateSampleConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if extra != "" {
+		f, err := os.OpenFile(config, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(extra)
+		f.Close()
+	}
+	if err := CreateSampleTemplate(filepath.Join(dir, "mail.html")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &App{ConfigPath: config}
+}
+
+func TestLockDatabase(t *testing.T) {
+	first := newCLITestApp(t, "")
+	if err := first.load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.lockDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := &App{ConfigPath: first.ConfigPath}
+	if err := second.load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.lockDatabase(); !errors.Is(err, errDatabaseLocked) {
+		t.Fatalf("second lock: %v, want errDatabaseLocked", err)
+	}
+
+	first.Close()
+	if err := second.lockDatabase(); err != nil {
+		t.Fatalf("lock after Close: %v", err)
+	}
+	second.Close()
+}
+
+func TestSendRefusesLockedDatabase(t *testing.T) {
+	holder := newCLITestApp(t, "")
+	if err := holder.load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.lockDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI(&App{ConfigPath: holder.ConfigPath}, []string{"send"}, &stdout, &stderr)
+	if code != exitError || !strings.Contains(stderr.String(), "in use") {
+		t.Errorf("send = %d, %q; want exit %d and the lock error", code, stderr.String(), exitError)
+	}
+}
+
+func TestSendStartsTrackingServer(t *testing.T) {
+	// A port already in use shows that send starts the server itself
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	a := newCLITestApp(t, `
+tracking:
+  listen: `+busy.Addr().String()+`
+  base_url: https://track.example.com
+  secret: 0123456789abcdef
+  clicks: true
+`)
+	var stdout, stderr bytes.Buffer
+	code := RunCLI(a, []string{"send"}, &stdout, &stderr)
+	if code != exitError || !strings.Contains(stderr.String(), "failed to start tracking server") {
+		t.Errorf("send = %d, %q; want the tracking server error", code, stderr.String())
+	}
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package app
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile: Takes an exclusive flock without waiting
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errDatabaseLocked
+	}
+	return err
+}
+
+// waitLockFile: Takes an exclusive flock, waiting for the holder to release
+// it
+func waitLockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
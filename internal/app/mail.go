@@ -3,9 +3,13 @@
 package app
 
 import (
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,27 +50,66 @@ func htmlToPlainText(html string) string {
 	return text
 }
 
-// SendMail: Belirtilen config ile email gönderir
-func SendMail(cfg *Config, to, subject, htmlBody string) error {
+// NewMessageID: RFC 5322 msg-id that is globally unique and traceable to
+// our From domain: <unix-nanos-base36.random-hex@from-domain>
+func NewMessageID(fromEmail string) (string, error) {
+	domain := ""
+	if i := strings.LastIndex(fromEmail, "@"); i >= 0 {
+		domain = strings.ToLower(strings.Trim(fromEmail[i+1:], " <>"))
+	}
+	if domain == "" {
+		domain, _ = os.Hostname()
+	}
+	if domain == "" {
+		domain = "localhost"
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate Message-ID: %v", err)
+	}
+	return "<" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + hex.EncodeToString(random) + "@" + domain + ">", nil
+}
+
+// SendMail: Belirtilen config ile email gönderir. htmlBody is expected to be
+// rendered for the recipient already (see App.renderBody).
+func SendMail(cfg *Config, to, subject, htmlBody string, opts MailOptions) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", m.FormatAddress(cfg.SMTP.FromEmail, cfg.SMTP.FromName))
 	m.SetHeader("To", to)
 	m.SetHeader("Subject", subject)
 	m.SetHeader("Reply-To", cfg.SMTP.FromEmail)
 	m.SetHeader("MIME-Version", "1.0")
-	m.SetHeader("Message-ID", fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), strings.Split(to, "@")[0], cfg.SMTP.Host))
+	messageID := opts.MessageID
+	if messageID == "" {
+		var err error
+		if messageID, err = NewMessageID(cfg.SMTP.FromEmail); err != nil {
+			return err
+		}
+	}
+	m.SetHeader("Message-ID", messageID)
 	m.SetHeader("Date", time.Now().Format(time.RFC1123Z))
-	m.SetHeader("List-Unsubscribe", fmt.Sprintf("<https://ipieconference.org/unsubscribe/?email=%s>", to))
-	m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
 
-	// Template'deki {{email}} placeholder'ını değiştir
-	body := strings.ReplaceAll(htmlBody, "{{email}}", to)
+	// List-Unsubscribe: https (one-click, RFC 8058) and/or mailto targets
+	var unsubscribe []string
+	if opts.UnsubscribeURL != "" {
+		unsubscribe = append(unsubscribe, "<"+opts.UnsubscribeURL+">")
+	}
+	if opts.UnsubscribeMailto != "" {
+		unsubscribe = append(unsubscribe, "<"+opts.UnsubscribeMailto+">")
+	}
+	if len(unsubscribe) > 0 {
+		m.SetHeader("List-Unsubscribe", strings.Join(unsubscribe, ", "))
+	}
+	if opts.UnsubscribeURL != "" {
+		m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
 
 	// HTML'den plain text otomatik üret
-	plainText := htmlToPlainText(body)
+	plainText := htmlToPlainText(htmlBody)
 
 	m.SetBody("text/plain", plainText)
-	m.AddAlternative("text/html", body)
+	m.AddAlternative("text/html", htmlBody)
 
 	d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password)
 
@@ -79,5 +122,16 @@ func SendMail(cfg *Config, to, subject, htmlBody string) error {
 		}
 	}
 
-	return d.DialAndSend(m)
+	s, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Envelope sender (Return-Path) may differ from From, e.g. for VERP
+	envelopeFrom := cfg.SMTP.FromEmail
+	if opts.EnvelopeFrom != "" {
+		envelopeFrom = opts.EnvelopeFrom
+	}
+	return s.Send(envelopeFrom, []string{to}, m)
 }
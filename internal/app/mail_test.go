@@ -0,0 +1,24 @@
+package app
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewMessageID(t *testing.T) {
+	format := regexp.MustCompile(`^<[0-9a-z]+\.[0-9a-f]{16}@example\.com>$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := NewMessageID("News <news@Example.com>")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(id) {
+			t.Fatalf("NewMessageID = %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("NewMessageID repeated %q", id)
+		}
+		seen[id] = true
+	}
+}
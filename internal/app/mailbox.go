@@ -0,0 +1,289 @@
+// mailbox.go: Reads messages from local Maildir and mbox mailboxes and walks
+// their MIME parts
+
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var mboxFromEscapeRegex = regexp.MustCompile(`^>+From `)
+
+// readMailbox calls fn for every new message in a Maildir and/or mbox.
+// Maildir messages are moved from new/ to cur/ once handled; for mbox the
+// processed byte offset is remembered in a "<mbox>.offset" file.
+func readMailbox(maildir, mbox string, fn func(msg *mail.Message) error) (int, error) {
+	count := 0
+	if maildir != "" {
+		n, err := readMaildir(maildir, fn)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	if mbox != "" {
+		n, err := readMbox(mbox, fn)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func readMaildir(dir string, fn func(msg *mail.Message) error) (int, error) {
+	newDir := filepath.Join(dir, "new")
+	entries, err := os.ReadDir(newDir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(newDir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return count, err
+		}
+		if msg, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+			if err := fn(msg); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		// Mark as seen so the message is not processed again
+		name := entry.Name()
+		if !strings.Contains(name, ":2,") {
+			name += ":2,S"
+		}
+		if err := os.Rename(path, filepath.Join(dir, "cur", name)); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func readMbox(path string, fn func(msg *mail.Message) error) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	offsetPath := path + ".offset"
+	offset := int64(0)
+	if data, err := os.ReadFile(offsetPath); err == nil {
+		offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if offset > info.Size() {
+		// The mbox was truncated or rotated, start over
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var current bytes.Buffer
+	inMessage := false
+	prevBlank := true
+
+	flush := func() error {
+		if !inMessage || current.Len() == 0 {
+			return nil
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(current.Bytes()))
+		current.Reset()
+		if err != nil {
+			return nil
+		}
+		count++
+		return fn(msg)
+	}
+
+	// Only the size seen above is read, so the saved offset never skips
+	// what is appended meanwhile
+	reader := bufio.NewReader(io.LimitReader(file, info.Size()-offset))
+	pos := offset   // start of the next line
+	start := offset // "From " line of the message being read
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if prevBlank && strings.HasPrefix(line, "From ") {
+				if ferr := flush(); ferr != nil {
+					return count, ferr
+				}
+				inMessage = true
+				start = pos
+			} else if inMessage {
+				if mboxFromEscapeRegex.MatchString(line) {
+					line = line[1:]
+				}
+				current.WriteString(line)
+			}
+			prevBlank = strings.TrimRight(line, "\r\n") == ""
+			pos += int64(len(line))
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+
+	// The last message is complete once the blank line that ends it is
+	// written; one still being appended is read again next time
+	if inMessage && !prevBlank {
+		pos = start
+	} else if err := flush(); err != nil {
+		return count, err
+	}
+
+	return count, os.WriteFile(offsetPath, []byte(strconv.FormatInt(pos, 10)+"\n"), 0644)
+}
+
+// mimePart is a decoded leaf part of a message
+type mimePart struct {
+	mediaType string
+	params    map[string]string
+	body      []byte
+}
+
+// messageParts: Returns the decoded leaf parts of a message together with
+// the top-level media type and parameters. The body is put back, so the
+// complaint and the bounce parsers can both read the same message.
+func messageParts(msg *mail.Message) (string, map[string]string, []mimePart) {
+	header := textproto.MIMEHeader(msg.Header)
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	body, _ := io.ReadAll(msg.Body)
+	msg.Body = bytes.NewReader(body)
+
+	var parts []mimePart
+	walkParts(header, bytes.NewReader(body), &parts, 0)
+	return mediaType, params, parts
+}
+
+func walkParts(header textproto.MIMEHeader, body io.Reader, parts *[]mimePart, depth int) {
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < 10 {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err != nil {
+				return
+			}
+			walkParts(p.Header, p, parts, depth+1)
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return
+	}
+	*parts = append(*parts, mimePart{mediaType: mediaType, params: params, body: data})
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// newlineStripper drops CR/LF so base64 bodies with line breaks decode
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		kept := 0
+		for _, b := range p[:count] {
+			if b != '\r' && b != '\n' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// parseHeaderBlocks: Parses blank-line separated header blocks such as the
+// body of a message/delivery-status or message/feedback-report part
+func parseHeaderBlocks(data []byte) []textproto.MIMEHeader {
+	var blocks []textproto.MIMEHeader
+	normalized := strings.ReplaceAll(string(data), "\r\n", "\n")
+	for _, chunk := range strings.Split(normalized, "\n\n") {
+		chunk = strings.Trim(chunk, "\n")
+		if chunk == "" {
+			continue
+		}
+		r := textproto.NewReader(bufio.NewReader(strings.NewReader(chunk + "\n\n")))
+		h, err := r.ReadMIMEHeader()
+		if err != nil && len(h) == 0 {
+			continue
+		}
+		blocks = append(blocks, h)
+	}
+	return blocks
+}
+
+// embeddedHeaders: Parses the headers of an attached message/rfc822 or
+// text/rfc822-headers part
+func embeddedHeaders(data []byte) mail.Header {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		// Headers-only parts may lack the terminating blank line
+		msg, err = mail.ReadMessage(bytes.NewReader(append(data, "\r\n\r\n"...)))
+		if err != nil {
+			return nil
+		}
+	}
+	return msg.Header
+}
+
+// stripAddressType: "rfc822; user@example.com" -> "user@example.com"
+func stripAddressType(value string) string {
+	if _, addr, ok := strings.Cut(value, ";"); ok {
+		value = addr
+	}
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
@@ -0,0 +1,42 @@
+// personalize.go: Mail-merge placeholders for subjects and templates
+
+package app
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// {{name}} or {{name|fallback}}
+var placeholderRegex = regexp.MustCompile(`\{\{\s*([a-z0-9_]+)\s*(?:\|([^}]*))?\}\}`)
+
+// personalize: Replaces {{email}} and {{field}} placeholders with the
+// recipient's values. Unset fields use the fallback after "|", or nothing.
+// {{unsubscribe_url}} is left for renderBody. Values are HTML-escaped when
+// escape is set.
+func personalize(text string, r *Recipient, escape bool) string {
+	return placeholderRegex.ReplaceAllStringFunc(text, func(match string) string {
+		m := placeholderRegex.FindStringSubmatch(match)
+		key, fallback := m[1], strings.TrimSpace(m[2])
+
+		var value string
+		switch {
+		case key == "unsubscribe_url":
+			return match
+		case key == "email":
+			value = r.Email
+		case systemFields[key]:
+			value = ""
+		default:
+			value = r.Fields[key]
+		}
+		if value == "" {
+			value = fallback
+		}
+		if escape {
+			value = html.EscapeString(value)
+		}
+		return value
+	})
+}
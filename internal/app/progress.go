@@ -0,0 +1,96 @@
+// progress.go: Progress and cancellation of imports running in the background
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+var errImportCancelled = errors.New("import cancelled")
+
+// ImportProgress reports how far an import has read its source and lets it
+// be cancelled. The import goroutine updates it while the TUI renders it.
+type ImportProgress struct {
+	// Task is "Reading" while planning and "Importing" while committing
+	Task   string
+	Source string
+
+	read      atomic.Int64
+	total     atomic.Int64
+	rows      atomic.Int64
+	cancelled atomic.Bool
+}
+
+// Cancel stops the import at the next row
+func (p *ImportProgress) Cancel() {
+	p.cancelled.Store(true)
+}
+
+// Cancelled reports whether Cancel was called
+func (p *ImportProgress) Cancelled() bool {
+	return p.cancelled.Load()
+}
+
+// open: Opens a file of known size whose reads are counted
+func (p *ImportProgress) open(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(0)
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{p.track(file, size), file}, nil
+}
+
+// track: Counts the bytes read from r towards size. Without a progress the
+// reader is returned as is.
+func (p *ImportProgress) track(r io.Reader, size int64) io.Reader {
+	if p == nil {
+		return r
+	}
+	p.read.Store(0)
+	p.total.Store(size)
+	return &countingReader{r: r, n: &p.read}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+const progressBarWidth = 40
+
+// importProgressContent: Renders a running import, with a bar when the size
+// of the source is known
+func importProgressContent(p *ImportProgress) string {
+	content := fmt.Sprintf("%s %s\n\n", p.Task, filepath.Base(p.Source))
+	read, total, rows := p.read.Load(), p.total.Load(), p.rows.Load()
+	if total > 0 {
+		done := min(read*progressBarWidth/total, progressBarWidth)
+		content += fmt.Sprintf("[%s%s] %3d%%\n", strings.Repeat("#", int(done)), strings.Repeat("-", progressBarWidth-int(done)), min(read*100/total, 100))
+		content += fmt.Sprintf("%s of %s, %d rows\n", formatSize(read), formatSize(total), rows)
+	} else {
+		content += fmt.Sprintf("%d rows\n", rows)
+	}
+	if p.Cancelled() {
+		return content + "\nCancelling..."
+	}
+	return content + "\nEsc to cancel"
+}
@@ -5,7 +5,7 @@ import (
 	"time"
 )
 
-func CreateSampleConfig() error {
+func CreateSampleConfig(path string) error {
 	sample := `smtp:
   host: smtp.example.com
   port: 587
@@ -22,7 +22,7 @@ mail:
 database:
   path: data.txt
 `
-	return os.WriteFile("config.yaml", []byte(sample), 0644)
+	return os.WriteFile(path, []byte(sample), 0644)
 }
 
 func CreateSampleTemplate(path string) error {
@@ -0,0 +1,213 @@
+// sqlite.go: Recipient import from a SELECT on a local SQLite database,
+// read with a pure-Go driver so the binary stays free of cgo
+
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// isSQLiteFile: .db, .sqlite and .sqlite3 files are queried
+func isSQLiteFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// checkSelect: Queries must start with SELECT or WITH. This only catches
+// mistakes; openSQLite keeps the query from writing.
+func checkSelect(query string) error {
+	words := strings.Fields(strings.ToUpper(query))
+	if len(words) == 0 || (words[0] != "SELECT" && words[0] != "WITH") {
+		return errors.New("only SELECT queries can be imported")
+	}
+	return nil
+}
+
+// sqliteConn is a connection to a SQLite file opened by openSQLite
+type sqliteConn struct {
+	*sql.Conn
+	db *sql.DB
+}
+
+// openSQLite: Opens a database file read-only and with query_only set, and
+// disallows ATTACH, so that no statement, nor several chained with ";",
+// can change a database or create a file. The driver has none of the file
+// functions of the sqlite3 shell, such as writefile(), and extensions
+// cannot be loaded.
+func openSQLite(ctx context.Context, path string) (*sqliteConn, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dsn := "file:" + (&url.URL{Path: filepath.ToSlash(abs)}).EscapedPath() + "?mode=ro&_pragma=query_only(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		_, err = sqlite.Limit(conn, sqlite3.SQLITE_LIMIT_ATTACHED, 0)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteConn{Conn: conn, db: db}, nil
+}
+
+func (c *sqliteConn) Close() error {
+	c.Conn.Close()
+	return c.db.Close()
+}
+
+// queryOutput streams the result of a query as CSV, header row included
+type queryOutput struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// runQuery: Runs a query on a database opened with openSQLite and writes
+// its rows as CSV to the returned reader as they are read
+func runQuery(path, query string) (*queryOutput, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db, err := openSQLite(ctx, path)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		cancel()
+		db.Close()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	q := &queryOutput{PipeReader: pr, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(q.done)
+		defer db.Close()
+		defer rows.Close()
+		pw.CloseWithError(writeRows(csv.NewWriter(pw), rows))
+	}()
+	return q, nil
+}
+
+// writeRows: Writes the column names and then every row as CSV. NULLs are
+// written as empty cells.
+func writeRows(w *csv.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = v.String
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Close stops a query that has not been read to the end
+func (q *queryOutput) Close() error {
+	q.cancel()
+	q.PipeReader.Close()
+	<-q.done
+	return nil
+}
+
+// sqliteTables: Tables and views of a database, for picking a query
+func sqliteTables(path string) ([]string, error) {
+	db, err := openSQLite(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), "SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// defaultSQLiteQuery: The configured query, or all rows of the first table
+func (a *App) defaultSQLiteQuery(tables []string) string {
+	if a.cfg.Import.SQLiteQuery != "" {
+		return a.cfg.Import.SQLiteQuery
+	}
+	if len(tables) > 0 {
+		return fmt.Sprintf(`SELECT * FROM "%s"`, strings.ReplaceAll(tables[0], `"`, `""`))
+	}
+	return ""
+}
+
+// OpenSQLiteImport runs a SELECT on a SQLite file and prepares the column
+// mapping of its result like that of a CSV file
+func (a *App) OpenSQLiteImport(path, query string) (*CSVImport, error) {
+	if err := checkSelect(query); err != nil {
+		return nil, err
+	}
+	c := &CSVImport{Path: path, Query: strings.TrimSpace(query), Delimiter: ',', HasHeader: true}
+	if err := c.readSample(); err != nil {
+		return nil, err
+	}
+	if len(c.Sample) < 2 {
+		return nil, errors.New("query returned no rows")
+	}
+	c.guessMapping()
+	return c, nil
+}
+
+// sqliteQueryContent: Renders the query step of a SQLite import
+func sqliteQueryContent(path string, tables []string) string {
+	content := fmt.Sprintf("Query %s\n\n", path)
+	if len(tables) > 0 {
+		content += "Tables: " + strings.Join(tables, ", ") + "\n\n"
+	}
+	content += "Enter to run the SELECT and map its columns, Esc to cancel"
+	return content
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSQLite: SQLite file with a contacts table
+func newTestSQLite(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE contacts (email TEXT, name TEXT, lang TEXT)",
+		"INSERT INTO contacts VALUES ('bob@example.com', 'Bob', 'de'), ('ann@example.com', NULL, 'fr'), ('nope', 'X', NULL)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestSQLiteImport(t *testing.T) {
+	// A leading dash must not be taken for an option
+	path := newTestSQLite(t, "-contacts.db")
+	tables, err := sqliteTables(path)
+	if err != nil || len(tables) != 1 || tables[0] != "contacts" {
+		t.Fatalf("tables %v, %v", tables, err)
+	}
+
+	a := newTestApp(t)
+	c, err := a.OpenSQLiteImport(path, "SELECT * FROM contacts ORDER BY rowid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mapping[0] != columnEmail || c.Mapping[1] != FieldName || c.Mapping[2] != FieldLang {
+		t.Errorf("mapping %q", c.Mapping)
+	}
+	var rows []ImportRow
+	skipped, err := c.eachRow(nil, func(row ImportRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 || len(rows) != 2 || rows[1].Email != "ann@example.com" || rows[1].Fields[FieldName] != "" {
+		t.Errorf("rows %+v, skipped %d", rows, skipped)
+	}
+
+	// Stopping early ends the query
+	_, err = c.eachRow(nil, func(ImportRow) error { return errImportCancelled })
+	if err != errImportCancelled {
+		t.Errorf("err = %v", err)
+	}
+
+	if _, err := a.OpenSQLiteImport(path, "SELECT * FROM contacts WHERE 0"); err == nil {
+		t.Error("empty result accepted")
+	}
+}
+
+func TestSQLiteQueriesCannotWrite(t *testing.T) {
+	path := newTestSQLite(t, "contacts.db")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "x")
+
+	a := newTestApp(t)
+	for _, query := range []string{
+		"DELETE FROM contacts",
+		"SELECT writefile('" + target + "', 'pwned')",
+		"SELECT 1; DELETE FROM contacts",
+		"WITH x AS (SELECT 1) DELETE FROM contacts",
+		"SELECT load_extension('" + target + "')",
+		"SELECT 1; ATTACH '" + target + "' AS x; CREATE TABLE x.t (a)",
+		"SELECT * FROM contacts; ATTACH '" + target + "' AS x",
+		"SELECT 1; VACUUM INTO '" + target + "'",
+		"SELECT 1; PRAGMA query_only = 0; DELETE FROM contacts",
+	} {
+		c, err := a.OpenSQLiteImport(path, query)
+		if err == nil {
+			_, err = c.eachRow(nil, func(ImportRow) error { return nil })
+		}
+		if err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("database changed")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files created: %v", entries)
+	}
+}
@@ -0,0 +1,274 @@
+// suppression.go: Do-not-contact list shared across campaigns
+
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultSuppressionPath = "suppression.txt"
+
+// SuppressionEntry is an address (user@example.com) or a whole domain
+// (example.com) that must never be mailed
+type SuppressionEntry struct {
+	Date   time.Time
+	Entry  string
+	Reason string
+}
+
+// IsDomain reports whether the entry suppresses a whole domain
+func (e SuppressionEntry) IsDomain() bool {
+	return !strings.Contains(e.Entry, "@")
+}
+
+// SuppressionList is a loaded suppression file
+type SuppressionList struct {
+	Entries []SuppressionEntry
+	index   map[string]int
+}
+
+// Match returns the entry suppressing an address, checking the address
+// first and then its domain and parent domains
+func (l *SuppressionList) Match(email string) (SuppressionEntry, bool) {
+	if l == nil {
+		return SuppressionEntry{}, false
+	}
+	email = normalizeSuppression(email)
+	if i, ok := l.index[email]; ok {
+		return l.Entries[i], true
+	}
+
+	_, domain, ok := strings.Cut(email, "@")
+	for ok && domain != "" {
+		if i, found := l.index[domain]; found {
+			return l.Entries[i], true
+		}
+		_, domain, ok = strings.Cut(domain, ".")
+	}
+	return SuppressionEntry{}, false
+}
+
+// normalizeSuppression: Lowercases and strips a leading "@" or "*@"
+func normalizeSuppression(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	entry = strings.TrimPrefix(entry, "*")
+	return strings.TrimPrefix(entry, "@")
+}
+
+// validSuppression: Entries must look like an address or a domain
+func validSuppression(entry string) bool {
+	if entry == "" || strings.ContainsAny(entry, " ;") {
+		return false
+	}
+	local, domain, isAddress := strings.Cut(entry, "@")
+	if isAddress && local == "" {
+		return false
+	}
+	if !isAddress {
+		domain = entry
+	}
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// suppressionPath: Configured suppression list path or the default
+func (a *App) suppressionPath() string {
+	if a.cfg.Suppression.Path != "" {
+		return a.cfg.Suppression.Path
+	}
+	return a.cfg.resolve(defaultSuppressionPath)
+}
+
+// readSuppressions reads the suppression file; a missing file is empty
+func readSuppressions(path string) (*SuppressionList, error) {
+	list := &SuppressionList{index: make(map[string]int)}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), ";")
+		if len(parts) < 2 {
+			continue
+		}
+		entry := SuppressionEntry{Entry: normalizeSuppression(parts[1])}
+		if entry.Entry == "" {
+			continue
+		}
+		entry.Date, _ = time.Parse(time.RFC3339, strings.TrimSpace(parts[0]))
+		if len(parts) >= 3 {
+			entry.Reason = strings.TrimSpace(parts[2])
+		}
+		if _, dup := list.index[entry.Entry]; dup {
+			continue
+		}
+		list.index[entry.Entry] = len(list.Entries)
+		list.Entries = append(list.Entries, entry)
+	}
+	return list, scanner.Err()
+}
+
+// LoadSuppressions loads the suppression list at path
+func LoadSuppressions(path string) (*SuppressionList, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	return readSuppressions(path)
+}
+
+// cachedSuppressions: The suppression list, read again only when the file
+// has changed since the last call, as the dispatcher asks on every tick
+func (a *App) cachedSuppressions() (*SuppressionList, error) {
+	path := a.suppressionPath()
+	stamp, err := stampSource(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	list := a.suppressions
+	if stamp != a.suppressionsStamp {
+		list = nil
+	}
+	a.mu.Unlock()
+	if list != nil {
+		return list, nil
+	}
+
+	// Stamped before reading, so a change during the read is read next time
+	list, err = LoadSuppressions(path)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.suppressions, a.suppressionsStamp = list, stamp
+	a.mu.Unlock()
+	return list, nil
+}
+
+// AddSuppression appends an entry to the suppression list unless it is
+// already listed. Lines use the database layout:
+// {ISO8601_DATE} ; {ENTRY} ; {REASON}
+func AddSuppression(path, entry, reason string) error {
+	entry = normalizeSuppression(entry)
+	if !validSuppression(entry) {
+		return fmt.Errorf("invalid suppression entry %q: expected an address or a domain", entry)
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	list, err := readSuppressions(path)
+	if err != nil {
+		return err
+	}
+	if _, exists := list.index[entry]; exists {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line := time.Now().UTC().Format(time.RFC3339) + " ; " + entry
+	if reason != "" {
+		line += " ; " + strings.ReplaceAll(reason, ";", ",")
+	}
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+// RemoveSuppression removes an entry from the suppression list
+func RemoveSuppression(path, entry string) error {
+	entry = normalizeSuppression(entry)
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	db := NewDatabase(path)
+	lines, err := db.readLines()
+	if err != nil {
+		return err
+	}
+
+	kept := lines[:0]
+	removed := false
+	for _, line := range lines {
+		parts := strings.Split(line, ";")
+		if len(parts) >= 2 && normalizeSuppression(parts[1]) == entry {
+			removed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !removed {
+		return fmt.Errorf("%s is not in the suppression list", entry)
+	}
+	return db.writeLines(kept)
+}
+
+// AddSuppressionEntry: Adds "entry [reason...]" typed on the Suppression screen
+func (a *App) AddSuppressionEntry(input string) error {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return errors.New("nothing to add")
+	}
+	reason := strings.Join(fields[1:], " ")
+	if reason == "" {
+		reason = "added manually"
+	}
+	if err := AddSuppression(a.suppressionPath(), fields[0], reason); err != nil {
+		return err
+	}
+	a.addLog(fmt.Sprintf("Suppressed %s (%s)", normalizeSuppression(fields[0]), reason))
+	return nil
+}
+
+// RemoveSuppressionEntry: Removes an entry from the Suppression screen
+func (a *App) RemoveSuppressionEntry(entry string) error {
+	if err := RemoveSuppression(a.suppressionPath(), entry); err != nil {
+		return err
+	}
+	a.addLog(fmt.Sprintf("Removed %s from the suppression list", entry))
+	return nil
+}
+
+// suppressionContent: Renders the suppression list for the TUI
+func (a *App) suppressionContent(selected int) (string, []string) {
+	list, err := LoadSuppressions(a.suppressionPath())
+	if err != nil {
+		return fmt.Sprintf("Error loading %s: %v", a.suppressionPath(), err), nil
+	}
+
+	content := fmt.Sprintf("Suppression list (%s): %d entries\n\n", a.suppressionPath(), len(list.Entries))
+	entries := make([]string, 0, len(list.Entries))
+	for i, e := range list.Entries {
+		entries = append(entries, e.Entry)
+		prefix := "  "
+		if i == selected {
+			prefix = "> "
+		}
+		kind := "address"
+		if e.IsDomain() {
+			kind = "domain "
+		}
+		date := "-"
+		if !e.Date.IsZero() {
+			date = e.Date.Format("2006-01-02")
+		}
+		content += fmt.Sprintf("%s%s  %s  %-40s %s\n", prefix, date, kind, e.Entry, e.Reason)
+	}
+	return content, entries
+}
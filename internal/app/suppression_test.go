@@ -0,0 +1,39 @@
+package app
+
+import "testing"
+
+func TestCachedSuppressions(t *testing.T) {
+	a := newTestApp(t)
+
+	first, err := a.cachedSuppressions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Entries) != 0 {
+		t.Fatalf("missing file gave %v", first.Entries)
+	}
+	if again, _ := a.cachedSuppressions(); again != first {
+		t.Error("unchanged missing file was read again")
+	}
+
+	if err := AddSuppression(a.suppressionPath(), "spam.example", "complaints"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := a.cachedSuppressions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := second.Match("user@spam.example"); !ok {
+		t.Error("added entry not seen")
+	}
+	if again, _ := a.cachedSuppressions(); again != second {
+		t.Error("unchanged file was read again")
+	}
+
+	if err := RemoveSuppression(a.suppressionPath(), "spam.example"); err != nil {
+		t.Fatal(err)
+	}
+	if third, _ := a.cachedSuppressions(); len(third.Entries) != 0 {
+		t.Errorf("removed entry still cached: %v", third.Entries)
+	}
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignTokenRoundTrip(t *testing.T) {
+	const secret = "0123456789abcdef"
+	token := signToken(secret, tokenClick, "ann@example.com", "https://example.com/a?b=c&d=é")
+	if url.PathEscape(token) != token {
+		t.Errorf("token %q is not URL-safe", token)
+	}
+	values, err := verifyToken(secret, tokenClick, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(values, "|") != "ann@example.com|https://example.com/a?b=c&d=é" {
+		t.Errorf("values %q", values)
+	}
+}
+
+func TestVerifyTokenRejects(t *testing.T) {
+	const secret = "0123456789abcdef"
+	token := signToken(secret, tokenUnsubscribe, "ann@example.com")
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := signToken(secret+"x", tokenUnsubscribe, "bob@example.com")
+
+	tests := []struct {
+		name, secret, purpose, token string
+	}{
+		{"other purpose", secret, tokenClick, token},
+		{"other secret", secret + "x", tokenUnsubscribe, token},
+		{"no secret", "", tokenUnsubscribe, token},
+		{"signed with another secret", secret, tokenUnsubscribe, forged},
+		{"payload of another token", secret, tokenUnsubscribe, strings.Split(forged, ".")[0] + "." + sig},
+		{"cut signature", secret, tokenUnsubscribe, payload + "." + sig[:len(sig)-1]},
+		{"no signature", secret, tokenUnsubscribe, payload},
+		{"empty", secret, tokenUnsubscribe, ""},
+	}
+	for _, tt := range tests {
+		if values, err := verifyToken(tt.secret, tt.purpose, tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: verifyToken = %q, %v; want ErrInvalidToken", tt.name, values, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// tracking.go: Click and open tracking through rewritten links, a tracking
+// pixel and an embedded HTTP server
+
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const trackingShutdownTimeout = 5 * time.Second
+
+var (
+	linkHrefRegex  = regexp.MustCompile(`(?i)(<a\s[^>]*?href\s*=\s*)("([^"]*)"|'([^']*)')`)
+	bodyCloseRegex = regexp.MustCompile(`(?i)</body\s*>`)
+)
+
+// pixelGIF is a transparent 1x1 GIF
+var pixelGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// linkID: Short stable identifier for a link URL
+func linkID(link string) string {
+	sum := sha256.Sum256([]byte(link))
+	return hex.EncodeToString(sum[:4])
+}
+
+// trackableLink: Only absolute http(s) links are rewritten
+func trackableLink(link string) bool {
+	lower := strings.ToLower(strings.TrimSpace(link))
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// templateLinks: Returns the trackable links of a template keyed by link ID
+func templateLinks(html string) map[string]string {
+	links := make(map[string]string)
+	for _, m := range linkHrefRegex.FindAllStringSubmatch(html, -1) {
+		link := m[3] + m[4]
+		if trackableLink(link) {
+			links[linkID(link)] = link
+		}
+	}
+	return links
+}
+
+// addLinks: Remembers the links of a loaded template for click stats
+func (a *App) addLinks(body []byte) {
+	if a.links == nil {
+		a.links = make(map[string]string)
+	}
+	for id, link := range templateLinks(string(body)) {
+		a.links[id] = link
+	}
+}
+
+// rewriteLinks: Replaces every trackable href with the URL returned by fn
+func rewriteLinks(html string, fn func(link string) string) string {
+	return linkHrefRegex.ReplaceAllStringFunc(html, func(match string) string {
+		m := linkHrefRegex.FindStringSubmatch(match)
+		link := m[3] + m[4]
+		if !trackableLink(link) {
+			return match
+		}
+		return m[1] + `"` + fn(link) + `"`
+	})
+}
+
+// trackingURL: Builds a public URL on the tracking server
+func (a *App) trackingURL(path, token string) string {
+	return strings.TrimRight(a.cfg.Tracking.BaseURL, "/") + path + token
+}
+
+// clickURL: Tracking URL for a recipient following a template link
+func (a *App) clickURL(email, link string) string {
+	return a.trackingURL("/c/", signToken(a.cfg.Tracking.Secret, tokenClick, email, link))
+}
+
+// injectPixel: Inserts a tracking pixel before </body>, or appends it
+func (a *App) injectPixel(html, email string) string {
+	src := a.trackingURL("/o/", signToken(a.cfg.Tracking.Secret, tokenOpen, email)+".gif")
+	pixel := `<img src="` + src + `" width="1" height="1" alt="" style="display:block;width:1px;height:1px;border:0">`
+
+	if loc := bodyCloseRegex.FindAllStringIndex(html, -1); len(loc) > 0 {
+		i := loc[len(loc)-1][0]
+		return html[:i] + pixel + html[i:]
+	}
+	return html + pixel
+}
+
+// trackingEnabled: The server runs when any tracking feature is enabled
+func (a *App) trackingEnabled() bool {
+	return a.cfg.Tracking.Clicks || a.cfg.Tracking.Opens || a.cfg.Unsubscribe.Enabled
+}
+
+// startTracking: Starts the embedded tracking HTTP server
+func (a *App) startTracking() error {
+	if !a.trackingEnabled() {
+		return nil
+	}
+	t := a.cfg.Tracking
+	if t.Listen == "" || t.BaseURL == "" {
+		return errors.New("tracking and unsubscribe require tracking.listen and tracking.base_url")
+	}
+	if len(t.Secret) < 16 {
+		return errors.New("tracking.secret must be at least 16 characters")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/c/", a.handleClick)
+	mux.HandleFunc("/o/", a.handleOpen)
+	mux.HandleFunc("/u/", a.handleUnsubscribe)
+
+	// Listening here reports a port in use at startup instead of in the logs
+	listener, err := net.Listen("tcp", t.Listen)
+	if err != nil {
+		return err
+	}
+	a.server = &http.Server{
+		Addr:              t.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.addLog(fmt.Sprintf("Tracking server error: %v", err))
+		}
+	}()
+	a.addLog(fmt.Sprintf("Tracking server listening on %s", t.Listen))
+	return nil
+}
+
+// handleClick: Records a click and redirects to the original link
+func (a *App) handleClick(w http.ResponseWriter, r *http.Request) {
+	values, err := verifyToken(a.cfg.Tracking.Secret, tokenClick, strings.TrimPrefix(r.URL.Path, "/c/"))
+	if err != nil || len(values) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	email, link := values[0], values[1]
+
+	if err := RecordClick(a.cfg.Database.Path, email, linkID(link)); err != nil {
+		a.addLog(fmt.Sprintf("RecordClick error: %v", err))
+	}
+
+	target := strings.ReplaceAll(link, "{{email}}", url.QueryEscape(email))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOpen: Records an open and serves the tracking pixel
+func (a *App) handleOpen(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/o/"), ".gif")
+	if values, err := verifyToken(a.cfg.Tracking.Secret, tokenOpen, token); err == nil && len(values) == 1 {
+		if err := RecordOpen(a.cfg.Database.Path, values[0], time.Now()); err != nil {
+			a.addLog(fmt.Sprintf("RecordOpen error: %v", err))
+		}
+	}
+
+	// Always answer with the pixel so broken tokens don't show as broken images
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Write(pixelGIF)
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTrackingTestApp: App with click and open tracking on and a sent
+// recipient
+func newTrackingTestApp(t *testing.T) *App {
+	t.Helper()
+	a := newTestApp(t, "2026-01-01T00:00:00Z ; DONE ; ann@example.com")
+	a.cfg.Tracking.Secret = "0123456789abcdef"
+	a.cfg.Tracking.BaseURL = "https://t.example.com"
+	a.cfg.Tracking.Clicks = true
+	a.cfg.Tracking.Opens = true
+	return a
+}
+
+func TestHandleClick(t *testing.T) {
+	a := newTrackingTestApp(t)
+	link := "https://example.com/offer?to={{email}}"
+	path := strings.TrimPrefix(a.clickURL("ann@example.com", link), a.cfg.Tracking.BaseURL)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		a.handleClick(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("click: %d, want %d", w.Code, http.StatusFound)
+		}
+		if got := w.Header().Get("Location"); got != "https://example.com/offer?to=ann%40example.com" {
+			t.Errorf("redirected to %q", got)
+		}
+	}
+	if got := readTestRecords(t, a)[0].Field(FieldClicks); got != linkID(link)+":2" {
+		t.Errorf("clicks %q, want %s:2", got, linkID(link))
+	}
+
+	// A link signed with another secret is not followed
+	forged := "/c/" + signToken("fedcba9876543210", tokenClick, "ann@example.com", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	a.handleClick(w, httptest.NewRequest(http.MethodGet, forged, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("forged click: %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandleOpen(t *testing.T) {
+	a := newTrackingTestApp(t)
+	src := a.injectPixel("<body></body>", "ann@example.com")
+	start := strings.Index(src, a.cfg.Tracking.BaseURL) + len(a.cfg.Tracking.BaseURL)
+	path := src[start : strings.Index(src, `.gif"`)+len(".gif")]
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		a.handleOpen(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" || !bytes.Equal(w.Body.Bytes(), pixelGIF) {
+			t.Fatalf("open: %d %q, want the pixel", w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+	record := readTestRecords(t, a)[0]
+	if record.Field(FieldOpens) != "2" || record.Field(FieldFirstOpen) == "" {
+		t.Errorf("opens %q, first open %q; want 2 and a time", record.Field(FieldOpens), record.Field(FieldFirstOpen))
+	}
+
+	// A broken token still gets the pixel, but no open is recorded
+	w := httptest.NewRecorder()
+	a.handleOpen(w, httptest.NewRequest(http.MethodGet, "/o/broken.gif", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), pixelGIF) {
+		t.Errorf("broken token: %d, want the pixel", w.Code)
+	}
+	if got := readTestRecords(t, a)[0].Field(FieldOpens); got != "2" {
+		t.Errorf("opens %q after a broken token, want 2", got)
+	}
+}
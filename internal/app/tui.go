@@ -1,8 +1,9 @@
 package app
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
@@ -18,9 +19,15 @@ type model struct {
 	viewport     viewport.Model
 	app          *App
 	delayInput   textinput.Model
+	entryInput   textinput.Model
+	searchInput  textinput.Model
+	fieldInput   textinput.Model
+	filterInput  textinput.Model
+	queryInput   textinput.Model
 	width        int
 	height       int
 	confirmStart bool
+	quitting     bool
 	help         help.Model
 	keys         keyMap
 }
@@ -32,6 +39,33 @@ func (m *model) Init() tea.Cmd {
 	ti.Width = 20
 
 	m.delayInput = ti
+
+	ei := textinput.New()
+	ei.Placeholder = "address or domain, then an optional reason"
+	ei.Width = 60
+	m.entryInput = ei
+
+	si := textinput.New()
+	si.Placeholder = "address or Message-ID"
+	si.Width = 60
+	m.searchInput = si
+
+	fi := textinput.New()
+	fi.Placeholder = "field name, empty to skip"
+	fi.Width = 30
+	m.fieldInput = fi
+
+	fl := textinput.New()
+	fl.Placeholder = "part of a file name"
+	fl.Width = 30
+	m.filterInput = fl
+
+	qi := textinput.New()
+	qi.Placeholder = "SELECT email, name FROM contacts"
+	qi.CharLimit = 0
+	qi.Width = 70
+	m.queryInput = qi
+
 	m.width = 80
 	m.height = 20
 	m.confirmStart = false
@@ -61,6 +95,18 @@ func (m *model) Init() tea.Cmd {
 			key.WithKeys("5", "e"),
 			key.WithHelp("e", "pending"),
 		),
+		Preview: key.NewBinding(
+			key.WithKeys("6", "v"),
+			key.WithHelp("v", "preview"),
+		),
+		Suppression: key.NewBinding(
+			key.WithKeys("7", "x"),
+			key.WithHelp("x", "suppression"),
+		),
+		Search: key.NewBinding(
+			key.WithKeys("8", "/"),
+			key.WithHelp("/", "search"),
+		),
 		Boot: key.NewBinding(
 			key.WithKeys("b", "B"),
 			key.WithHelp("B", "boot"),
@@ -94,22 +140,34 @@ func (m *model) renderScreen() {
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
+	var cmd, importCmd tea.Cmd
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		input := m.activeInput()
+		inputFocused, inputValue := false, ""
+		if input != nil {
+			inputFocused, inputValue = input.Focused(), input.Value()
+		}
 		action := m.app.HandleKeyPress(
 			msg.String(),
 			m.screen,
 			m.confirmStart,
 			m.app.viewData.IsRunning,
-			m.delayInput.Focused(),
+			inputFocused,
 			m.app.viewData.SelectedFile,
 			m.app.viewData.ImportFiles,
-			m.delayInput.Value(),
+			inputValue,
 		)
 
 		if action.ShouldQuit {
+			// Let a running import stop between two writes
+			if p := m.app.viewData.ImportProgress; p != nil {
+				p.Cancel()
+				m.quitting = true
+				m.renderScreen()
+				return m, nil
+			}
 			return m, tea.Quit
 		}
 
@@ -119,11 +177,38 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		if action.BlurInput {
 			m.delayInput.Blur()
+			m.entryInput.Blur()
+			m.searchInput.Blur()
+			m.fieldInput.Blur()
+			m.filterInput.Blur()
+			m.queryInput.Blur()
 		}
 
 		if action.FocusInput {
-			m.delayInput.SetValue(fmt.Sprintf("%d", m.app.viewData.DelaySeconds))
-			m.delayInput.Focus()
+			switch m.screen {
+			case 2:
+				m.delayInput.SetValue(fmt.Sprintf("%d", m.app.viewData.DelaySeconds))
+				m.delayInput.Focus()
+			case 6:
+				m.entryInput.SetValue("")
+				m.entryInput.Focus()
+			case 3:
+				if m.app.viewData.SQLiteFile != "" {
+					m.queryInput.Focus()
+				} else if c := m.app.viewData.CSVImport; c != nil {
+					value := c.Mapping[m.app.viewData.SelectedColumn]
+					if value == columnEmail {
+						value = ""
+					}
+					m.fieldInput.SetValue(value)
+					m.fieldInput.Focus()
+				} else {
+					m.filterInput.SetValue(m.app.viewData.ImportFilter)
+					m.filterInput.Focus()
+				}
+			case 7:
+				m.searchInput.Focus()
+			}
 		}
 
 		if action.ClearLogs {
@@ -158,15 +243,110 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.app.viewData.DelaySeconds = action.UpdateDelay
 		}
 
+		if action.OpenDir != "" {
+			m.app.viewData.ImportDir = filepath.Clean(action.OpenDir)
+			m.app.viewData.ImportFilter = ""
+			m.app.viewData.SelectedFile = 0
+			m.refreshImportFiles()
+		}
+
+		if action.NextExtension {
+			m.app.viewData.ImportExt = nextExtension(m.app.viewData.ImportExt)
+			m.app.viewData.SelectedFile = 0
+			m.refreshImportFiles()
+		}
+
+		if action.ClearFilter {
+			m.filterInput.SetValue("")
+			m.app.viewData.ImportFilter = ""
+			m.refreshImportFiles()
+		}
+
 		if action.ImportFile != "" {
-			err := m.app.ImportEmailsFromFile(action.ImportFile)
+			app, file := m.app, action.ImportFile
+			p := &ImportProgress{Task: "Reading", Source: file}
+			importCmd = m.runImport(p, func() importDoneMsg {
+				plan, err := app.PlanFileImport(file, p)
+				return importDoneMsg{source: file, plan: plan, err: err}
+			})
+		}
+
+		if action.CancelProgress {
+			m.app.viewData.ImportProgress.Cancel()
+		}
+
+		if action.OpenCSV != "" {
+			c, err := OpenCSVImport(action.OpenCSV)
 			if err != nil {
-				m.app.addLog(fmt.Sprintf("Error importing %s: %v", action.ImportFile, err))
+				m.app.addLog(fmt.Sprintf("Error reading %s: %v", action.OpenCSV, err))
 			} else {
-				m.setScreen(0)
+				m.app.viewData.CSVImport = c
+				m.app.viewData.SelectedColumn = 0
 			}
 		}
 
+		if action.ShowHistory {
+			m.app.viewData.ShowImportHistory = true
+			m.app.viewData.SelectedImport = 0
+			m.refreshImportHistory()
+			m.viewport.GotoTop()
+		}
+
+		if action.OpenSQLite != "" {
+			m.openSQLite(action.OpenSQLite)
+		}
+
+		if action.RunQuery != "" {
+			c, err := m.app.OpenSQLiteImport(m.app.viewData.SQLiteFile, action.RunQuery)
+			if err != nil {
+				m.app.addLog(fmt.Sprintf("Error querying %s: %v", m.app.viewData.SQLiteFile, err))
+			} else {
+				m.app.viewData.SQLiteFile = ""
+				m.app.viewData.CSVImport = c
+				m.app.viewData.SelectedColumn = 0
+			}
+			m.app.viewData.ImportContent = m.generateImportContent()
+		}
+
+		if action.CancelQuery {
+			m.app.viewData.SQLiteFile = ""
+			m.app.viewData.ImportContent = m.generateImportContent()
+		}
+
+		if m.app.viewData.ImportProgress != nil {
+			// Keys are not applied to the steps behind a running import
+		} else if plan := m.app.viewData.ImportPlan; plan != nil && m.screen == 3 {
+			importCmd = m.updatePreview(plan, action)
+		} else if c := m.app.viewData.CSVImport; c != nil && m.screen == 3 {
+			importCmd = m.updateMapping(c, action)
+		} else if m.app.viewData.ShowImportHistory && m.screen == 3 {
+			m.updateHistory(action)
+		}
+
+		if action.SuppressionSelected >= 0 {
+			m.app.viewData.SelectedSuppression = action.SuppressionSelected
+			m.refreshSuppression()
+		}
+
+		if action.AddSuppression != "" {
+			if err := m.app.AddSuppressionEntry(action.AddSuppression); err != nil {
+				m.app.addLog(fmt.Sprintf("Error adding suppression: %v", err))
+			}
+			m.refreshSuppression()
+		}
+
+		if action.RemoveSuppression != "" {
+			if err := m.app.RemoveSuppressionEntry(action.RemoveSuppression); err != nil {
+				m.app.addLog(fmt.Sprintf("Error removing suppression: %v", err))
+			}
+			m.refreshSuppression()
+		}
+
+		if action.Search != "" {
+			m.app.viewData.SearchContent = m.app.searchContent(action.Search)
+			m.viewport.GotoTop()
+		}
+
 		if action.ToggleHelp {
 			m.help.ShowAll = !m.help.ShowAll
 		}
@@ -181,6 +361,22 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		if m.screen == 2 {
 			m.delayInput, cmd = m.delayInput.Update(msg)
+		} else if m.screen == 6 && m.entryInput.Focused() {
+			m.entryInput, cmd = m.entryInput.Update(msg)
+		} else if m.screen == 3 && m.queryInput.Focused() {
+			m.queryInput, cmd = m.queryInput.Update(msg)
+		} else if m.screen == 3 && m.fieldInput.Focused() {
+			m.fieldInput, cmd = m.fieldInput.Update(msg)
+		} else if m.screen == 3 && m.filterInput.Focused() {
+			m.filterInput, cmd = m.filterInput.Update(msg)
+			// Filter as you type
+			if value := m.filterInput.Value(); value != m.app.viewData.ImportFilter {
+				m.app.viewData.ImportFilter = value
+				m.app.viewData.SelectedFile = 0
+				m.refreshImportFiles()
+			}
+		} else if m.screen == 7 && m.searchInput.Focused() && !action.ScreenChanged {
+			m.searchInput, cmd = m.searchInput.Update(msg)
 		}
 		m.renderScreen()
 
@@ -191,6 +387,32 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
 
+	case progressTickMsg:
+		if m.app.viewData.ImportProgress == nil {
+			return m, nil
+		}
+		m.renderScreen()
+		return m, progressTick()
+
+	case importDoneMsg:
+		m.app.viewData.ImportProgress = nil
+		switch {
+		case errors.Is(msg.err, errImportCancelled):
+			m.app.addLog(fmt.Sprintf("Import of %s cancelled", msg.source))
+		case msg.err != nil:
+			m.app.addLog(fmt.Sprintf("Error importing %s: %v", msg.source, msg.err))
+		}
+		if m.quitting {
+			return m, tea.Quit
+		}
+		if msg.plan != nil {
+			m.showImportPlan(msg.plan)
+		} else if msg.committed && m.screen == 3 {
+			m.setScreen(0)
+		}
+		m.app.viewData.ImportContent = m.generateImportContent()
+		m.renderScreen()
+
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		if m.width < 80 {
@@ -204,37 +426,225 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.viewport = viewport.New(m.width, height)
 		m.renderScreen()
 	}
-	return m, cmd
+	return m, tea.Batch(cmd, importCmd)
+}
+
+// runImport shows the progress of an import step on the Import screen while
+// step runs in the background
+func (m *model) runImport(p *ImportProgress, step func() importDoneMsg) tea.Cmd {
+	m.app.viewData.ImportProgress = p
+	return tea.Batch(func() tea.Msg { return step() }, progressTick())
+}
+
+func progressTick() tea.Cmd {
+	return tea.Tick(200*time.Millisecond, func(t time.Time) tea.Msg { return progressTickMsg(t) })
 }
 
 func (m *model) setScreen(screen int) {
 	m.screen = screen
 	if screen == 3 {
-		files, err := os.ReadDir(".")
-		if err == nil {
-			m.app.viewData.ImportFiles = []string{}
-			for _, file := range files {
-				if !file.IsDir() {
-					m.app.viewData.ImportFiles = append(m.app.viewData.ImportFiles, file.Name())
-				}
-			}
+		if m.app.viewData.ImportDir == "" {
+			m.app.viewData.ImportDir = "."
 		}
 		m.app.viewData.SelectedFile = 0
-		m.app.viewData.ImportContent = m.generateImportContent()
+		m.app.viewData.CSVImport = nil
+		m.app.viewData.ImportPlan = nil
+		m.app.viewData.ShowImportHistory = false
+		m.app.viewData.ConfirmUndo = false
+		m.app.viewData.SQLiteFile = ""
+		m.refreshImportFiles()
+	}
+	if screen == 5 {
+		m.app.viewData.PreviewContent = m.app.previewContent()
+		m.viewport.GotoTop()
+	}
+	if screen == 6 {
+		m.app.viewData.SelectedSuppression = 0
+		m.refreshSuppression()
 	}
 }
 
-func (m model) generateImportContent() string {
-	content := "Select a file to import emails:\n\n"
-	for i, file := range m.app.viewData.ImportFiles {
-		if i == m.app.viewData.SelectedFile {
-			content += "> " + file + "\n"
-		} else {
-			content += "  " + file + "\n"
+// activeInput returns the text input of the current screen, if any
+func (m *model) activeInput() *textinput.Model {
+	switch m.screen {
+	case 2:
+		return &m.delayInput
+	case 3:
+		if m.app.viewData.ImportProgress != nil {
+			return nil
 		}
+		if m.app.viewData.SQLiteFile != "" {
+			return &m.queryInput
+		}
+		if m.app.viewData.CSVImport != nil {
+			return &m.fieldInput
+		}
+		if m.app.viewData.ImportPlan == nil && !m.app.viewData.ShowImportHistory {
+			return &m.filterInput
+		}
+	case 6:
+		return &m.entryInput
+	case 7:
+		return &m.searchInput
 	}
-	content += "\nUse Up/Down to select, Enter to import"
-	return content
+	return nil
+}
+
+func (m *model) refreshSuppression() {
+	content, entries := m.app.suppressionContent(m.app.viewData.SelectedSuppression)
+	m.app.viewData.SuppressionEntries = entries
+	if m.app.viewData.SelectedSuppression >= len(entries) {
+		m.app.viewData.SelectedSuppression = max(len(entries)-1, 0)
+		content, entries = m.app.suppressionContent(m.app.viewData.SelectedSuppression)
+		m.app.viewData.SuppressionEntries = entries
+	}
+	m.app.viewData.SuppressionContent = content
+}
+
+// updateMapping applies the column mapping keys of a CSV import
+func (m *model) updateMapping(c *CSVImport, action KeyAction) tea.Cmd {
+	selected := m.app.viewData.SelectedColumn
+	var err error
+	switch {
+	case action.ColumnSelected >= 0:
+		m.app.viewData.SelectedColumn = action.ColumnSelected
+	case action.MapColumn != "":
+		err = c.SetColumn(selected, action.MapColumn)
+	case action.SkipColumn:
+		err = c.SetColumn(selected, "")
+	case action.ToggleHeader:
+		c.ToggleHeader()
+	case action.NextDelimiter:
+		err = c.NextDelimiter()
+	case action.CommitImport:
+		app, source := m.app, c.Source()
+		p := &ImportProgress{Task: "Reading", Source: source}
+		return m.runImport(p, func() importDoneMsg {
+			plan, err := app.PlanCSVImport(c, p)
+			return importDoneMsg{source: source, plan: plan, err: err}
+		})
+	case action.CancelImport:
+		m.app.viewData.CSVImport = nil
+		m.app.addLog(fmt.Sprintf("Import of %s cancelled", c.Source()))
+	}
+	if err != nil {
+		m.app.addLog(fmt.Sprintf("Error importing %s: %v", c.Source(), err))
+	}
+	if n := len(c.Mapping); m.app.viewData.SelectedColumn >= n {
+		m.app.viewData.SelectedColumn = max(n-1, 0)
+	}
+	m.app.viewData.ImportContent = m.generateImportContent()
+	return nil
+}
+
+// showImportPlan switches the Import screen to the preview of a plan
+func (m *model) showImportPlan(plan *ImportPlan) {
+	m.app.viewData.ImportPlan = plan
+	m.app.viewData.ImportContent = m.generateImportContent()
+	m.viewport.GotoTop()
+}
+
+// updatePreview applies the confirm/cancel keys of an import preview
+func (m *model) updatePreview(plan *ImportPlan, action KeyAction) tea.Cmd {
+	switch {
+	case action.CommitImport:
+		m.app.viewData.ImportPlan = nil
+		app := m.app
+		p := &ImportProgress{Task: "Importing", Source: plan.Source}
+		return m.runImport(p, func() importDoneMsg {
+			_, err := app.CommitImport(plan, p)
+			return importDoneMsg{source: plan.Source, committed: true, err: err}
+		})
+	case action.CancelImport:
+		// Back to the column mapping of a CSV, or to the file list
+		m.app.viewData.ImportPlan = nil
+		m.app.addLog(fmt.Sprintf("Import %s of %s cancelled", plan.ID, plan.Source))
+	}
+	m.app.viewData.ImportContent = m.generateImportContent()
+	return nil
+}
+
+// openSQLite switches the Import screen to the query step of a SQLite file
+func (m *model) openSQLite(path string) {
+	tables, err := sqliteTables(path)
+	if err != nil {
+		m.app.addLog(fmt.Sprintf("Error reading %s: %v", path, err))
+		return
+	}
+	m.app.viewData.SQLiteFile = path
+	m.app.viewData.SQLiteTables = tables
+	m.queryInput.SetValue(m.app.defaultSQLiteQuery(tables))
+	m.queryInput.CursorEnd()
+	m.queryInput.Focus()
+	m.app.viewData.ImportContent = m.generateImportContent()
+}
+
+// refreshImportFiles re-reads the directory shown in the file browser
+func (m *model) refreshImportFiles() {
+	vd := &m.app.viewData
+	files, err := m.app.listImportDir(vd.ImportDir, vd.ImportExt, vd.ImportFilter)
+	if err != nil {
+		m.app.addLog(fmt.Sprintf("Error reading %s: %v", vd.ImportDir, err))
+	} else {
+		vd.ImportFiles = files
+	}
+	if vd.SelectedFile >= len(vd.ImportFiles) {
+		vd.SelectedFile = max(len(vd.ImportFiles)-1, 0)
+	}
+	vd.ImportContent = m.generateImportContent()
+}
+
+// refreshImportHistory reloads the import history shown on the Import screen
+func (m *model) refreshImportHistory() {
+	batches, err := m.app.ImportHistory()
+	if err != nil {
+		m.app.addLog(fmt.Sprintf("Error reading %s: %v", m.app.importHistoryPath(), err))
+	}
+	m.app.viewData.ImportHistory = batches
+	if m.app.viewData.SelectedImport >= len(batches) {
+		m.app.viewData.SelectedImport = max(len(batches)-1, 0)
+	}
+	m.app.viewData.ImportContent = m.generateImportContent()
+}
+
+// updateHistory applies the selection and undo keys of the import history
+func (m *model) updateHistory(action KeyAction) {
+	switch {
+	case action.ImportSelected >= 0:
+		m.app.viewData.SelectedImport = action.ImportSelected
+	case action.ConfirmUndo:
+		m.app.viewData.ConfirmUndo = true
+	case action.CancelUndo:
+		m.app.viewData.ConfirmUndo = false
+	case action.UndoImport:
+		m.app.viewData.ConfirmUndo = false
+		batch := m.app.viewData.ImportHistory[m.app.viewData.SelectedImport]
+		if err := m.app.UndoImport(batch); err != nil {
+			m.app.addLog(fmt.Sprintf("Error undoing import %s: %v", batch.ID, err))
+		}
+		m.refreshImportHistory()
+		return
+	case action.CloseHistory:
+		m.app.viewData.ShowImportHistory = false
+	}
+	m.app.viewData.ImportContent = m.generateImportContent()
+}
+
+func (m model) generateImportContent() string {
+	if plan := m.app.viewData.ImportPlan; plan != nil {
+		return importPreviewContent(plan)
+	}
+	if path := m.app.viewData.SQLiteFile; path != "" {
+		return sqliteQueryContent(path, m.app.viewData.SQLiteTables)
+	}
+	if c := m.app.viewData.CSVImport; c != nil {
+		return csvMappingContent(c, m.app.viewData.SelectedColumn)
+	}
+	if m.app.viewData.ShowImportHistory {
+		return importHistoryContent(m.app.viewData.ImportHistory, m.app.viewData.SelectedImport, m.app.viewData.ConfirmUndo)
+	}
+	vd := m.app.viewData
+	return importBrowserContent(vd.ImportDir, vd.ImportFiles, vd.SelectedFile, vd.ImportExt, vd.ImportFilter)
 }
 
 func (m model) getContent() string {
@@ -249,9 +659,31 @@ func (m model) getContent() string {
 		content += "Delay: " + m.delayInput.View() + "\n"
 		content += "Enter to set"
 	case 3:
-		content = m.app.viewData.ImportContent
+		if p := m.app.viewData.ImportProgress; p != nil {
+			content = importProgressContent(p)
+			break
+		}
+		if m.app.viewData.SQLiteFile != "" {
+			content = "Query: " + m.queryInput.View() + "\n\n"
+		} else if m.fieldInput.Focused() {
+			content = "Field: " + m.fieldInput.View() + "\n\n"
+		} else if m.filterInput.Focused() {
+			content = "Filter: " + m.filterInput.View() + "\n"
+			content += "Enter to keep the filter, Esc to clear it\n\n"
+		}
+		content += m.app.viewData.ImportContent
 	case 4:
 		content = m.app.viewData.PendingContent
+	case 5:
+		content = m.app.viewData.PreviewContent
+	case 6:
+		content = "Add: " + m.entryInput.View() + "\n"
+		content += "Enter to add, d to remove selected, Up/Down to select\n\n"
+		content += m.app.viewData.SuppressionContent
+	case 7:
+		content = "Search: " + m.searchInput.View() + "\n"
+		content += "Enter to search by address or Message-ID, Up/Down to scroll\n\n"
+		content += m.app.viewData.SearchContent
 	}
 	if m.confirmStart {
 		content += "\n\nConfirm start mail sending? Press y to start, n to cancel"
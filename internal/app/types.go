@@ -1,7 +1,11 @@
 package app
 
 import (
+	"io"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/bubbles/key"
@@ -15,6 +19,28 @@ const (
 	StatusDone         = "DONE"
 	StatusFailed       = "FAILED"
 	StatusUnsubscribed = "UNSUBSCRIBED"
+	StatusSuppressed   = "SUPPRESSED"
+	StatusBounced      = "BOUNCED"
+	StatusComplained   = "COMPLAINED"
+	StatusInvalid      = "INVALID"
+)
+
+// Record field names
+const (
+	FieldVariant   = "variant"
+	FieldLang      = "lang"
+	FieldClicks    = "clicks"
+	FieldFirstOpen = "first_open"
+	FieldOpens     = "opens"
+	FieldBounce    = "bounce"
+	FieldMessageID = "message_id"
+	FieldComplaint = "complaint"
+	FieldName      = "name"
+	FieldOrg       = "org"
+	FieldImportID  = "import_id"
+	FieldImported  = "imported_at"
+	FieldWarning   = "warning"
+	FieldSentAt    = "sent_at"
 )
 
 // Screen constants
@@ -24,44 +50,200 @@ const (
 	ScreenPreferences
 	ScreenImport
 	ScreenPending
+	ScreenPreview
+	ScreenSuppression
+	ScreenSearch
 )
 
 type tickMsg time.Time
 
+// progressTickMsg redraws the progress of a running import
+type progressTickMsg time.Time
+
+// importDoneMsg ends an import step run in the background: the plan for the
+// preview, or the commit
+type importDoneMsg struct {
+	source    string
+	plan      *ImportPlan
+	committed bool
+	err       error
+}
+
 type Config struct {
 	SMTP struct {
-		Host      string `yaml:"host"`
-		Port      int    `yaml:"port"`
-		Username  string `yaml:"username"`
-		Password  string `yaml:"password"`
-		FromEmail string `yaml:"from_email"`
-		FromName  string `yaml:"from_name"`
+		Host       string `yaml:"host"`
+		Port       int    `yaml:"port"`
+		Username   string `yaml:"username"`
+		Password   string `yaml:"password"`
+		FromEmail  string `yaml:"from_email"`
+		FromName   string `yaml:"from_name"`
+		ReturnPath string `yaml:"return_path"`
 	} `yaml:"smtp"`
 
 	Mail struct {
-		DelaySeconds int    `yaml:"delay_seconds"`
-		Subject      string `yaml:"subject"`
-		Template     string `yaml:"template"`
+		DelaySeconds      int               `yaml:"delay_seconds"`
+		Subject           string            `yaml:"subject"`
+		Subjects          map[string]string `yaml:"subjects"`
+		Template          string            `yaml:"template"`
+		DefaultLang       string            `yaml:"default_lang"`
+		InlineCSS         bool              `yaml:"inline_css"`
+		Variants          []MailVariant     `yaml:"variants"`
+		VariantSampleSize int               `yaml:"variant_sample_size"`
+		// VariantWaitMinutes is how long opens and clicks are awaited once
+		// every variant reached the sample size, VariantMinEvents how many
+		// of them the variants need in all before a winner is picked
+		VariantWaitMinutes int `yaml:"variant_wait_minutes"`
+		VariantMinEvents   int `yaml:"variant_min_events"`
+		// VariantStatePath is where the A/B decision is kept across
+		// restarts (default variants.txt)
+		VariantStatePath string `yaml:"variant_state_path"`
 	} `yaml:"mail"`
 
 	Database struct {
 		Path string `yaml:"path"`
 	} `yaml:"database"`
+
+	Tracking struct {
+		Listen  string `yaml:"listen"`
+		BaseURL string `yaml:"base_url"`
+		Secret  string `yaml:"secret"`
+		Clicks  bool   `yaml:"clicks"`
+		Opens   bool   `yaml:"opens"`
+	} `yaml:"tracking"`
+
+	Unsubscribe struct {
+		Enabled bool   `yaml:"enabled"`
+		URL     string `yaml:"url"`
+		Mailto  string `yaml:"mailto"`
+	} `yaml:"unsubscribe"`
+
+	Suppression struct {
+		Path string `yaml:"path"`
+	} `yaml:"suppression"`
+
+	Import struct {
+		// FoldGmail treats Gmail addresses that differ only in dots,
+		// +tags or case as duplicates
+		FoldGmail bool `yaml:"fold_gmail"`
+		// HistoryPath is the import history file (default imports.txt)
+		HistoryPath string `yaml:"history_path"`
+		// WatchDir is a drop folder whose new files are imported
+		WatchDir string `yaml:"watch_dir"`
+		// JSONEmailPath is the dot-separated path of the address in JSON
+		// records, e.g. contact.email; guessed from the keys when empty
+		JSONEmailPath string `yaml:"json_email_path"`
+		// SQLiteQuery is the SELECT offered for SQLite files
+		SQLiteQuery string `yaml:"sqlite_query"`
+	} `yaml:"import"`
+
+	Validation struct {
+		CheckDNS           bool `yaml:"check_dns"`
+		DNSTimeoutSeconds  int  `yaml:"dns_timeout_seconds"`
+		RejectRoleAccounts bool `yaml:"reject_role_accounts"`
+		AllowDisposable    bool `yaml:"allow_disposable"`
+	} `yaml:"validation"`
+
+	Bounces MailboxConfig `yaml:"bounces"`
+
+	Complaints struct {
+		MailboxConfig  `yaml:",inline"`
+		MaxRatePercent float64 `yaml:"max_rate_percent"`
+		MinSent        int     `yaml:"min_sent"`
+	} `yaml:"complaints"`
+
+	// dir is the directory of the config file, against which relative
+	// paths are resolved
+	dir string
+}
+
+// MailboxConfig points at a local Maildir and/or mbox to poll
+type MailboxConfig struct {
+	Maildir         string `yaml:"maildir"`
+	Mbox            string `yaml:"mbox"`
+	IntervalSeconds int    `yaml:"interval_seconds"`
+}
+
+// MailOptions carries per-recipient header values for SendMail
+type MailOptions struct {
+	UnsubscribeURL    string
+	UnsubscribeMailto string
+	// EnvelopeFrom overrides the SMTP MAIL FROM (defaults to the From address)
+	EnvelopeFrom string
+	// MessageID is the Message-ID header; generated when empty
+	MessageID string
+}
+
+// RecordInfo is a database record as shown in search results
+type RecordInfo struct {
+	Timestamp time.Time
+	Status    string
+	Email     string
+	Error     string
+	Fields    map[string]string
+}
+
+// MailVariant is one arm of an A/B test. Empty Subject or Template fall back
+// to the values in the mail section.
+type MailVariant struct {
+	Name     string            `yaml:"name"`
+	Subject  string            `yaml:"subject"`
+	Subjects map[string]string `yaml:"subjects"`
+	Template string            `yaml:"template"`
+	Weight   int               `yaml:"weight"`
 }
 
 type Recipient struct {
-	Email  string
-	Status string
-	Error  string
+	Email   string
+	Status  string
+	Error   string
+	Variant string
+	Fields  map[string]string
 }
 
 type Stats struct {
-	Total        int
-	Sending      int
-	Pending      int
-	Sent         int
-	Failed       int
-	Unsubscribed int
+	Total   int `json:"total"`
+	Sending int `json:"sending"`
+	Pending int `json:"pending"`
+	Sent    int `json:"sent"`
+	// Delivered counts every record that was sent, including those that
+	// later bounced, complained or unsubscribed
+	Delivered    int                       `json:"delivered"`
+	Failed       int                       `json:"failed"`
+	Unsubscribed int                       `json:"unsubscribed"`
+	Suppressed   int                       `json:"suppressed"`
+	Bounced      int                       `json:"bounced"`
+	HardBounced  int                       `json:"hard_bounced"`
+	Complained   int                       `json:"complained"`
+	Invalid      int                       `json:"invalid"`
+	Clicked      int                       `json:"clicked"`
+	Opened       int                       `json:"opened"`
+	Opens        int                       `json:"opens"`
+	Variants     map[string]*VariantStats  `json:"variants,omitempty"`
+	Languages    map[string]*LanguageStats `json:"languages,omitempty"`
+	Links        map[string]*LinkStats     `json:"links,omitempty"`
+}
+
+// VariantStats holds per-variant send results
+type VariantStats struct {
+	// Sent counts every delivered record, as Stats.Delivered does
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Clicked int `json:"clicked"`
+	Opened  int `json:"opened"`
+}
+
+// LinkStats holds click counts for one template link
+type LinkStats struct {
+	Unique int `json:"unique"`
+	Total  int `json:"total"`
+}
+
+// LanguageStats holds per-language recipient counts
+type LanguageStats struct {
+	Total   int `json:"total"`
+	Pending int `json:"pending"`
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
 }
 
 type ViewData struct {
@@ -73,13 +255,40 @@ type ViewData struct {
 	Logs           []string
 	Stats          Stats
 	DelaySeconds   int
-	ImportFiles    []string
+	ImportFiles    []ImportEntry
 	SelectedFile   int
 	TabNames       []string
 	LogsContent    string
 	StatsContent   string
 	PendingContent string
 	ImportContent  string
+	PreviewContent string
+
+	SuppressionEntries  []string
+	SelectedSuppression int
+	SuppressionContent  string
+
+	SearchContent string
+
+	CSVImport      *CSVImport
+	SelectedColumn int
+	ImportPlan     *ImportPlan
+
+	ShowImportHistory bool
+	ImportHistory     []ImportBatch
+	SelectedImport    int
+	ConfirmUndo       bool
+
+	ImportDir    string
+	ImportExt    string
+	ImportFilter string
+
+	SQLiteFile   string
+	SQLiteTables []string
+
+	// ImportProgress is set while an import reads its source in the
+	// background
+	ImportProgress *ImportProgress
 }
 
 type PendingEmail struct {
@@ -93,6 +302,11 @@ type Job struct {
 
 // App represents the application state
 type App struct {
+	// ConfigPath overrides BULKMAIL_CONFIG and config.yaml; DBPath
+	// overrides database.path in the config
+	ConfigPath string
+	DBPath     string
+
 	cfg            *Config
 	htmlBody       []byte
 	mu             sync.Mutex
@@ -105,6 +319,31 @@ type App struct {
 	Watcher        *fsnotify.Watcher
 	viewData       ViewData
 	noPendingCount int
+	templates      map[string][]byte
+	languages      map[string]bool
+	variantWinner  string
+	// variantSampled is when every variant first reached the sample size
+	variantSampled time.Time
+	// variantChecked is when the stats were last refreshed while the
+	// dispatcher held recipients back for the A/B winner
+	variantChecked time.Time
+	links          map[string]string
+	server         *http.Server
+	dbLock         *os.File
+	resolver       Resolver
+	done           chan struct{}
+	dropMu         sync.Mutex
+	dropTimers     map[string]*time.Timer
+	importMu       sync.Mutex
+	// committing counts imports writing to the database. Change events
+	// are not handled meanwhile, only noted in missedChange.
+	committing   atomic.Int32
+	missedChange atomic.Bool
+	// suppressions caches the suppression list read at suppressionsStamp
+	suppressions      *SuppressionList
+	suppressionsStamp sourceStamp
+	// logOut receives the logs of headless commands instead of the TUI
+	logOut io.Writer
 }
 
 type keyMap struct {
@@ -114,6 +353,9 @@ type keyMap struct {
 	Preferences key.Binding
 	Import      key.Binding
 	Pending     key.Binding
+	Preview     key.Binding
+	Suppression key.Binding
+	Search      key.Binding
 	Boot        key.Binding
 	Stop        key.Binding
 	Up          key.Binding
@@ -122,7 +364,7 @@ type keyMap struct {
 	Help        key.Binding
 }
 
-const tabLineText = "Logs | Stats | Preferences | Import | Pending"
+const tabLineText = "Logs | Stats | Preferences | Import | Pending | Preview | Suppression | Search"
 
 const (
 	colorTabLine       = "5"
@@ -141,7 +383,8 @@ func (k keyMap) ShortHelp() []key.Binding {
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Quit, k.Logs, k.Stats, k.Preferences},
-		{k.Import, k.Pending, k.Boot, k.Stop},
+		{k.Import, k.Pending, k.Preview, k.Suppression, k.Search},
+		{k.Boot, k.Stop},
 		{k.Up, k.Down, k.Clear, k.Help},
 	}
 }
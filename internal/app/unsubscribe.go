@@ -0,0 +1,135 @@
+// unsubscribe.go: RFC 8058 one-click unsubscribe endpoint and the
+// List-Unsubscribe targets advertised in outgoing mail
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Unsubscribe</title>
+</head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 40px auto; padding: 0 16px">
+{{if .Done}}
+<h1>You have been unsubscribed</h1>
+<p>{{.Email}} will not receive further emails from {{.From}}.</p>
+{{else}}
+<h1>Unsubscribe</h1>
+<p>Stop sending emails from {{.From}} to {{.Email}}?</p>
+<form method="post">
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<button type="submit">Unsubscribe</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// validateUnsubscribe: Checks the unsubscribe settings at startup. Every
+// unsubscribe target carries a signed token, so any of them needs the secret.
+func (a *App) validateUnsubscribe() error {
+	u := a.cfg.Unsubscribe
+	if u.URL == "" && !u.Enabled && u.Mailto == "" {
+		return nil
+	}
+	if u.URL != "" {
+		if !strings.Contains(u.URL, "{token}") {
+			return errors.New("unsubscribe.url must contain the {token} placeholder")
+		}
+		if !strings.HasPrefix(strings.ToLower(u.URL), "https://") {
+			return errors.New("unsubscribe.url must be an https URL (RFC 8058)")
+		}
+	}
+	if len(a.cfg.Tracking.Secret) < 16 {
+		return errors.New("tracking.secret must be at least 16 characters to sign unsubscribe tokens")
+	}
+	return nil
+}
+
+// unsubscribeToken: Signed token identifying a recipient for unsubscribing
+func (a *App) unsubscribeToken(email string) string {
+	return signToken(a.cfg.Tracking.Secret, tokenUnsubscribe, email)
+}
+
+// unsubscribeURL: One-click unsubscribe URL for a recipient, from the
+// unsubscribe.url template or the built-in endpoint. Empty if neither is set.
+func (a *App) unsubscribeURL(email string) string {
+	if a.cfg.Unsubscribe.URL != "" {
+		return strings.ReplaceAll(a.cfg.Unsubscribe.URL, "{token}", a.unsubscribeToken(email))
+	}
+	if a.cfg.Unsubscribe.Enabled {
+		return a.trackingURL("/u/", a.unsubscribeToken(email))
+	}
+	return ""
+}
+
+// unsubscribeMailto: mailto: unsubscribe target carrying the token in the
+// subject, or "" if no unsubscribe.mailto address is configured
+func (a *App) unsubscribeMailto(email string) string {
+	if a.cfg.Unsubscribe.Mailto == "" {
+		return ""
+	}
+	subject := "unsubscribe " + a.unsubscribeToken(email)
+	return "mailto:" + a.cfg.Unsubscribe.Mailto + "?subject=" + url.PathEscape(subject)
+}
+
+// handleUnsubscribe: GET shows a confirmation page, POST (one-click from
+// the mail client or the confirmation form) unsubscribes the recipient
+func (a *App) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	values, err := verifyToken(a.cfg.Tracking.Secret, tokenUnsubscribe, strings.TrimPrefix(r.URL.Path, "/u/"))
+	if err != nil || len(values) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	email := values[0]
+
+	data := struct {
+		Email string
+		From  string
+		Done  bool
+	}{Email: email, From: firstNonEmpty(a.cfg.SMTP.FromName, a.cfg.SMTP.FromEmail)}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if err := a.unsubscribe(email); err != nil {
+			a.addLog(fmt.Sprintf("Unsubscribe error for %s: %v", email, err))
+			http.Error(w, "unsubscribe failed, please try again later", http.StatusInternalServerError)
+			return
+		}
+		data.Done = true
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	unsubscribePage.Execute(w, data)
+}
+
+// unsubscribe: Marks the recipient UNSUBSCRIBED and suppresses the address
+func (a *App) unsubscribe(email string) error {
+	if err := AddSuppression(a.suppressionPath(), email, "unsubscribed"); err != nil {
+		return err
+	}
+	count, err := MarkUnsubscribed(a.cfg.Database.Path, email)
+	if err != nil {
+		return err
+	}
+
+	a.addLog(fmt.Sprintf("Unsubscribed %s (%d records)", email, count))
+	a.updateStats()
+	return nil
+}
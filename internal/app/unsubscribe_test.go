@@ -0,0 +1,115 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newUnsubscribeTestApp: App with the unsubscribe endpoint on and a sent
+// recipient
+func newUnsubscribeTestApp(t *testing.T) *App {
+	t.Helper()
+	a := newTestApp(t, "2026-01-01T00:00:00Z ; DONE ; ann@example.com")
+	a.cfg.Tracking.Secret = "0123456789abcdef"
+	a.cfg.Tracking.BaseURL = "https://t.example.com"
+	a.cfg.Unsubscribe.Enabled = true
+	a.cfg.SMTP.FromName = "Example News"
+	return a
+}
+
+func TestHandleUnsubscribe(t *testing.T) {
+	a := newUnsubscribeTestApp(t)
+	path := "/u/" + a.unsubscribeToken("ann@example.com")
+
+	w := httptest.NewRecorder()
+	a.handleUnsubscribe(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post">`) {
+		t.Fatalf("GET: %d\n%s", w.Code, w.Body)
+	}
+	if records := readTestRecords(t, a); records[0].Status != StatusDone {
+		t.Errorf("GET changed the status to %s", records[0].Status)
+	}
+	list, err := LoadSuppressions(a.suppressionPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := list.Match("ann@example.com"); ok {
+		t.Error("GET suppressed the address")
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	a.handleUnsubscribe(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "You have been unsubscribed") {
+		t.Fatalf("POST: %d\n%s", w.Code, w.Body)
+	}
+	if records := readTestRecords(t, a); records[0].Status != StatusUnsubscribed {
+		t.Errorf("status %s after POST, want %s", records[0].Status, StatusUnsubscribed)
+	}
+	if list, err = LoadSuppressions(a.suppressionPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := list.Match("ann@example.com"); !ok {
+		t.Error("address not suppressed after POST")
+	}
+}
+
+func TestHandleUnsubscribeRejects(t *testing.T) {
+	a := newUnsubscribeTestApp(t)
+	token := a.unsubscribeToken("ann@example.com")
+	tests := []struct {
+		name, method, path string
+		code               int
+	}{
+		{"bad token", http.MethodPost, "/u/" + token + "x", http.StatusNotFound},
+		{"token of another purpose", http.MethodGet, "/u/" + signToken(a.cfg.Tracking.Secret, tokenClick, "ann@example.com"), http.StatusNotFound},
+		{"no token", http.MethodGet, "/u/", http.StatusNotFound},
+		{"PUT", http.MethodPut, "/u/" + token, http.StatusMethodNotAllowed},
+		{"DELETE", http.MethodDelete, "/u/" + token, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.handleUnsubscribe(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+	if records := readTestRecords(t, a); records[0].Status != StatusDone {
+		t.Errorf("status %s after rejected requests, want %s", records[0].Status, StatusDone)
+	}
+}
+
+func TestValidateUnsubscribe(t *testing.T) {
+	const secret = "0123456789abcdef"
+	tests := []struct {
+		name    string
+		url     string
+		enabled bool
+		mailto  string
+		secret  string
+		ok      bool
+	}{
+		{"nothing configured", "", false, "", "", true},
+		{"endpoint", "", true, "", secret, true},
+		{"endpoint without secret", "", true, "", "", false},
+		{"url", "https://example.com/u/{token}", false, "", secret, true},
+		{"url without secret", "https://example.com/u/{token}", false, "", "short", false},
+		{"url without token", "https://example.com/u/", false, "", secret, false},
+		{"http url", "http://example.com/u/{token}", false, "", secret, false},
+		{"mailto", "", false, "unsubscribe@example.com", secret, true},
+		{"mailto without secret", "", false, "unsubscribe@example.com", "", false},
+	}
+	for _, tt := range tests {
+		a := newTestApp(t)
+		a.cfg.Unsubscribe.URL = tt.url
+		a.cfg.Unsubscribe.Enabled = tt.enabled
+		a.cfg.Unsubscribe.Mailto = tt.mailto
+		a.cfg.Tracking.Secret = tt.secret
+		if err := a.validateUnsubscribe(); (err == nil) != tt.ok {
+			t.Errorf("%s: validateUnsubscribe = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// validate.go: Address validation for imports: syntax, DNS, disposable and
+// role-account lists and common domain typos
+
+package app
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSTimeout = 5 * time.Second
+	dnsWorkers        = 8
+)
+
+var (
+	//go:embed lists/disposable_domains.txt
+	disposableList string
+	//go:embed lists/role_accounts.txt
+	roleList string
+
+	disposableDomains = parseList(disposableList)
+	roleAccounts      = parseList(roleList)
+
+	// RFC 5322 dot-atom and quoted-string local parts
+	dotAtomRegex      = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+/=?^_`{|}~-]+(\\.[A-Za-z0-9!#$%&'*+/=?^_`{|}~-]+)*$")
+	quotedLocalRegex  = regexp.MustCompile(`^"([\x20\x21\x23-\x5b\x5d-\x7e]|\\[\x20-\x7e])*"$`)
+	domainLabelRegex  = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	numericLabelRegex = regexp.MustCompile(`^[0-9]+$`)
+
+	// Domains that typos are checked against
+	popularDomains = []string{
+		"gmail.com", "googlemail.com", "yahoo.com", "yahoo.co.uk", "yahoo.fr",
+		"hotmail.com", "hotmail.co.uk", "hotmail.fr", "outlook.com", "live.com",
+		"msn.com", "icloud.com", "me.com", "aol.com", "gmx.de", "gmx.net",
+		"web.de", "yandex.com", "yandex.ru", "mail.ru", "protonmail.com",
+		"proton.me", "comcast.net", "verizon.net", "att.net", "orange.fr",
+		"free.fr", "libero.it", "t-online.de", "btinternet.com",
+	}
+	// Mistyped top-level domains of otherwise correct addresses. Two-letter
+	// ones are left out, as they are country codes.
+	tldTypos = map[string]string{
+		"con": "com", "cmo": "com", "ocm": "com", "vom": "com", "xom": "com",
+		"comm": "com", "nte": "net", "ogr": "org",
+	}
+)
+
+// parseList: One lowercase entry per line; blank lines and # comments skipped
+func parseList(data string) map[string]bool {
+	entries := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries[line] = true
+		}
+	}
+	return entries
+}
+
+// Resolver looks up the DNS records used to check that a domain accepts
+// mail. *net.Resolver implements it; tests can supply a fake.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Validator checks addresses before they are imported
+type Validator struct {
+	// Resolver is used for MX/A lookups; nil skips DNS checks
+	Resolver           Resolver
+	Timeout            time.Duration
+	RejectRoleAccounts bool
+	AllowDisposable    bool
+
+	mu      sync.Mutex
+	domains map[string]string
+	// typos caches the suggestion per domain, as large imports repeat
+	// the same few domains many times
+	typos map[string]string
+}
+
+// newValidator: Validator for the configured checks. DNS lookups use
+// a.resolver, or the system resolver when unset.
+func (a *App) newValidator() *Validator {
+	v := &Validator{
+		Timeout:            time.Duration(a.cfg.Validation.DNSTimeoutSeconds) * time.Second,
+		RejectRoleAccounts: a.cfg.Validation.RejectRoleAccounts,
+		AllowDisposable:    a.cfg.Validation.AllowDisposable,
+		domains:            make(map[string]string),
+		typos:              make(map[string]string),
+	}
+	if v.Timeout <= 0 {
+		v.Timeout = defaultDNSTimeout
+	}
+	if a.cfg.Validation.CheckDNS {
+		v.Resolver = a.resolver
+		if v.Resolver == nil {
+			v.Resolver = net.DefaultResolver
+		}
+	}
+	return v
+}
+
+// Check returns why a normalised address is invalid, or "" if it is valid
+func (v *Validator) Check(email string) string {
+	if reason := checkSyntax(email); reason != "" {
+		return reason
+	}
+	i := strings.LastIndex(email, "@")
+	local, domain := strings.ToLower(email[:i]), email[i+1:]
+
+	if isDisposable(domain) && !v.AllowDisposable {
+		return "disposable address"
+	}
+	if v.RejectRoleAccounts {
+		if base, _, _ := strings.Cut(local, "+"); roleAccounts[base] {
+			return "role account"
+		}
+	}
+	return v.checkDomain(domain)
+}
+
+// Suggest returns a warning when the domain of a valid address looks like
+// a typo of a popular provider, or "". Such addresses are still imported:
+// the domain may well be real.
+func (v *Validator) Suggest(email string) string {
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if isDisposable(domain) {
+		return ""
+	}
+	if suggestion := v.suggestDomain(domain); suggestion != "" {
+		return "possible typo, did you mean " + suggestion + "?"
+	}
+	return ""
+}
+
+// suggestDomain: suggestDomain, looked up once per domain
+func (v *Validator) suggestDomain(domain string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	suggestion, ok := v.typos[domain]
+	if !ok {
+		suggestion = suggestDomain(domain)
+		v.typos[domain] = suggestion
+	}
+	return suggestion
+}
+
+// checkSyntax: RFC 5321/5322 checks on a normalised (ASCII domain) address
+func checkSyntax(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i <= 0 || i == len(email)-1 {
+		return "missing local part or domain"
+	}
+	local, domain := email[:i], email[i+1:]
+
+	switch {
+	case len(email) > 254:
+		return "address longer than 254 characters"
+	case len(local) > 64:
+		return "local part longer than 64 characters"
+	}
+	for _, r := range local {
+		if r > 0x7e {
+			return "non-ASCII local part needs SMTPUTF8, which is not supported"
+		}
+	}
+	if !dotAtomRegex.MatchString(local) && !quotedLocalRegex.MatchString(local) {
+		return "invalid characters or dots in the local part"
+	}
+
+	if strings.HasPrefix(domain, "[") {
+		return "address literals are not accepted"
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "domain has no top-level domain"
+	}
+	for _, label := range labels {
+		if len(label) > 63 || !domainLabelRegex.MatchString(label) {
+			return "invalid domain label " + label
+		}
+	}
+	if numericLabelRegex.MatchString(labels[len(labels)-1]) {
+		return "numeric top-level domain"
+	}
+	return ""
+}
+
+// isDisposable: The domain or one of its parents is on the disposable list
+func isDisposable(domain string) bool {
+	for domain != "" {
+		if disposableDomains[domain] {
+			return true
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return false
+}
+
+// suggestDomain: Popular domain the given one is probably a typo of, or ""
+func suggestDomain(domain string) string {
+	for _, popular := range popularDomains {
+		if domain == popular {
+			return ""
+		}
+	}
+
+	if i := strings.LastIndex(domain, "."); i >= 0 {
+		if tld, ok := tldTypos[domain[i+1:]]; ok {
+			fixed := domain[:i+1] + tld
+			for _, popular := range popularDomains {
+				if fixed == popular {
+					return popular
+				}
+			}
+		}
+	}
+
+	best, bestDistance := "", 3
+	for _, popular := range popularDomains {
+		if countryVariant(domain, popular) {
+			continue
+		}
+		// Short domains are too close to real, unrelated ones to guess
+		limit := 0
+		switch {
+		case len(popular) >= 11:
+			limit = 2
+		case len(popular) >= 9:
+			limit = 1
+		}
+		if d := editDistance(domain, popular); d <= limit && d < bestDistance {
+			best, bestDistance = popular, d
+		}
+	}
+	return best
+}
+
+// countryVariant: The domains differ only in a country-code top-level
+// domain, like yahoo.co.jp and yahoo.co.uk, which are different providers
+func countryVariant(domain, popular string) bool {
+	i, j := strings.LastIndex(domain, "."), strings.LastIndex(popular, ".")
+	if i < 0 || j < 0 || domain[:i] != popular[:j] {
+		return false
+	}
+	return len(domain)-i-1 == 2 || len(popular)-j-1 == 2
+}
+
+// editDistance: Optimal string alignment distance (Levenshtein with
+// adjacent transpositions)
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// checkDomain: Cached MX/A check of a domain
+func (v *Validator) checkDomain(domain string) string {
+	if v.Resolver == nil {
+		return ""
+	}
+	v.mu.Lock()
+	reason, ok := v.domains[domain]
+	v.mu.Unlock()
+	if ok {
+		return reason
+	}
+
+	reason = v.lookupDomain(domain)
+	v.mu.Lock()
+	v.domains[domain] = reason
+	v.mu.Unlock()
+	return reason
+}
+
+// lookupDomain: A domain accepts mail if it has MX records (other than a
+// null MX) or, without MX, an address record. Temporary DNS failures are
+// not held against the address.
+func (v *Validator) lookupDomain(domain string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), v.Timeout)
+	defer cancel()
+
+	mx, err := v.Resolver.LookupMX(ctx, domain)
+	if err == nil && len(mx) > 0 {
+		if len(mx) == 1 && (mx[0].Host == "." || mx[0].Host == "") {
+			return "domain does not accept mail (null MX)"
+		}
+		return ""
+	}
+	if err != nil && !isNotFound(err) {
+		return ""
+	}
+
+	hosts, err := v.Resolver.LookupHost(ctx, domain)
+	if err == nil && len(hosts) > 0 {
+		return ""
+	}
+	if err != nil && !isNotFound(err) {
+		return ""
+	}
+	return "domain has no MX or A record"
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Prefetch looks up the domains of many addresses concurrently so Check
+// does not wait on them one by one
+func (v *Validator) Prefetch(emails []string) {
+	if v.Resolver == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range dnsWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range jobs {
+				v.checkDomain(domain)
+			}
+		}()
+	}
+	for _, email := range emails {
+		i := strings.LastIndex(email, "@")
+		if i < 0 {
+			continue
+		}
+		domain := email[i+1:]
+		if !seen[domain] && checkSyntax(email) == "" {
+			seen[domain] = true
+			jobs <- domain
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestValidatorCheck(t *testing.T) {
+	v := &Validator{RejectRoleAccounts: true, domains: make(map[string]string), typos: make(map[string]string)}
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"bob@example.com", ""},
+		{`"bob smith"@example.com`, ""},
+		{"bob.@example.com", "invalid characters or dots in the local part"},
+		{"bob@localhost", "domain has no top-level domain"},
+		{"bob@example.123", "numeric top-level domain"},
+		{"bob@[127.0.0.1]", "address literals are not accepted"},
+		{"bob@-example.com", "invalid domain label -example"},
+		{strings.Repeat("a", 65) + "@example.com", "local part longer than 64 characters"},
+		{"bob@mailinator.com", "disposable address"},
+		{"bob@sub.mailinator.com", "disposable address"},
+		{"info@example.com", "role account"},
+		{"info+news@example.com", "role account"},
+		// Typos are warnings, not failures
+		{"bob@gmial.com", ""},
+	}
+	for _, tt := range tests {
+		if got := v.Check(tt.email); got != tt.want {
+			t.Errorf("Check(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"gmial.com", "gmail.com"},
+		{"gmail.con", "gmail.com"},
+		{"hotmial.com", "hotmail.com"},
+		{"yahooo.co.uk", "yahoo.co.uk"},
+		{"outlok.com", "outlook.com"},
+		{"gmail.com", ""},
+		{"example.com", ""},
+		// Too short to guess
+		{"gmx.dr", ""},
+		// Real providers in other countries
+		{"yahoo.co.jp", ""},
+		{"yahoo.co.in", ""},
+		{"yahoo.co.id", ""},
+		{"hotmail.co.jp", ""},
+		{"hotmail.de", ""},
+		{"gmail.co", ""},
+	}
+	for _, tt := range tests {
+		if got := suggestDomain(tt.domain); got != tt.want {
+			t.Errorf("suggestDomain(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestValidatorSuggest(t *testing.T) {
+	v := &Validator{typos: make(map[string]string)}
+	if got := v.Suggest("bob@gmial.com"); got != "possible typo, did you mean gmail.com?" {
+		t.Errorf("Suggest = %q", got)
+	}
+	if got := v.Suggest("bob@yahoo.co.jp"); got != "" {
+		t.Errorf("Suggest = %q, want none for a country variant", got)
+	}
+}
+
+// fakeResolver answers from fixed MX and host tables
+type fakeResolver struct {
+	mx    map[string][]*net.MX
+	hosts map[string][]string
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if mx, ok := r.mx[name]; ok {
+		return mx, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if hosts, ok := r.hosts[host]; ok {
+		return hosts, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestValidatorCheckDNS(t *testing.T) {
+	v := &Validator{
+		Resolver: fakeResolver{
+			mx: map[string][]*net.MX{
+				"mx.example":     {{Host: "mail.mx.example.", Pref: 10}},
+				"nullmx.example": {{Host: ".", Pref: 0}},
+			},
+			hosts: map[string][]string{"a.example": {"192.0.2.1"}},
+		},
+		Timeout: defaultDNSTimeout,
+		domains: make(map[string]string),
+		typos:   make(map[string]string),
+	}
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"bob@mx.example", ""},
+		{"bob@a.example", ""},
+		{"bob@nullmx.example", "domain does not accept mail (null MX)"},
+		{"bob@none.example", "domain has no MX or A record"},
+	}
+	v.Prefetch([]string{"bob@mx.example", "bob@none.example"})
+	for _, tt := range tests {
+		if got := v.Check(tt.email); got != tt.want {
+			t.Errorf("Check(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"time"
+)
+
+// pickVariant: Deterministically picks a weighted variant for an email
+func pickVariant(email string, variants []MailVariant) string {
+	total := 0
+	for _, v := range variants {
+		total += variantWeight(v)
+	}
+	if total == 0 {
+		return ""
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	n := int(h.Sum32() % uint32(total))
+
+	for _, v := range variants {
+		n -= variantWeight(v)
+		if n < 0 {
+			return v.Name
+		}
+	}
+	return variants[len(variants)-1].Name
+}
+
+func variantWeight(v MailVariant) int {
+	if v.Weight <= 0 {
+		return 1
+	}
+	return v.Weight
+}
+
+func hasVariant(variants []MailVariant, name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, v := range variants {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTemplates: Validates configured variants and loads the base and
+// variant templates together with their language siblings
+func (a *App) loadTemplates() error {
+	a.templates = make(map[string][]byte)
+	a.languages = make(map[string]bool)
+	a.links = make(map[string]string)
+
+	if lang := normalizeLang(a.cfg.Mail.DefaultLang); lang != "" {
+		a.languages[lang] = true
+	}
+	for lang := range a.cfg.Mail.Subjects {
+		a.languages[normalizeLang(lang)] = true
+	}
+
+	if err := a.loadLocalized("", a.cfg.Mail.Template); err != nil {
+		return fmt.Errorf("failed to load template: %w", err)
+	}
+
+	if a.cfg.Mail.VariantSampleSize > 0 && !a.cfg.Tracking.Clicks && !a.cfg.Tracking.Opens {
+		// Delivery does not depend on the subject or template
+		return fmt.Errorf("mail.variant_sample_size needs tracking.clicks or tracking.opens to rank the variants")
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range a.cfg.Mail.Variants {
+		if v.Name == "" {
+			return fmt.Errorf("mail variant without a name")
+		}
+		if strings.ContainsAny(v.Name, ";=| ") {
+			return fmt.Errorf("mail variant %q: name must not contain spaces, ';', '|' or '='", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("duplicate mail variant %q", v.Name)
+		}
+		seen[v.Name] = true
+
+		for lang := range v.Subjects {
+			a.languages[normalizeLang(lang)] = true
+		}
+		if v.Template == "" {
+			continue
+		}
+		if err := a.loadLocalized(v.Name, v.Template); err != nil {
+			return fmt.Errorf("failed to load template for variant %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// activeVariants: Returns the variants new recipients may be assigned to.
+// Once a winner has been picked only the winner is offered.
+func (a *App) activeVariants() []MailVariant {
+	a.mu.Lock()
+	winner := a.variantWinner
+	a.mu.Unlock()
+
+	if winner != "" {
+		for _, v := range a.cfg.Mail.Variants {
+			if v.Name == winner {
+				return []MailVariant{v}
+			}
+		}
+	}
+	return a.cfg.Mail.Variants
+}
+
+// messageFor: Returns the subject and HTML body to send to a recipient.
+// What a variant sets is kept over the recipient's language: a variant
+// subject or template without a translation is sent untranslated rather
+// than replaced by the translated base, so the variant's results are about
+// its own message.
+func (a *App) messageFor(r *Recipient) (string, string) {
+	lang := a.recipientLang(r)
+
+	var variant MailVariant
+	for _, v := range a.cfg.Mail.Variants {
+		if v.Name == r.Variant {
+			variant = v
+			break
+		}
+	}
+
+	subject := firstNonEmpty(variant.Subjects[lang], variant.Subject)
+	if subject == "" {
+		subject = firstNonEmpty(a.cfg.Mail.Subjects[lang], a.cfg.Mail.Subject)
+	}
+
+	owner := ""
+	if _, ok := a.templates[templateKey(variant.Name, "")]; ok {
+		owner = variant.Name
+	}
+	for _, key := range []string{templateKey(owner, lang), templateKey(owner, "")} {
+		if body, ok := a.templates[key]; ok {
+			return subject, string(body)
+		}
+	}
+	return subject, string(a.htmlBody)
+}
+
+const (
+	defaultVariantWait      = time.Hour
+	defaultVariantMinEvents = 20
+	defaultVariantStatePath = "variants.txt"
+)
+
+// errVariantHold is returned by claimRecipient while the sample is complete
+// and the A/B winner not picked yet
+var errVariantHold = errors.New("holding back the remaining recipients until the A/B winner is picked")
+
+// checkVariantWinner: Picks the best variant once every variant has reached
+// the configured sample size, the wait for late opens and clicks is over
+// and enough of them came in. Variants are ranked by click rate, or by open
+// rate when only opens are tracked. A tie picks no winner yet.
+func (a *App) checkVariantWinner(stats Stats) {
+	if !a.abTesting() {
+		return
+	}
+	sample := a.cfg.Mail.VariantSampleSize
+
+	a.mu.Lock()
+	decided := a.variantWinner != ""
+	sampled := a.variantSampled
+	a.mu.Unlock()
+	if decided {
+		return
+	}
+
+	for _, v := range a.cfg.Mail.Variants {
+		if vs := stats.Variants[v.Name]; vs == nil || vs.Sent+vs.Failed < sample {
+			return
+		}
+	}
+	if sampled.IsZero() {
+		sampled = time.Now()
+		a.mu.Lock()
+		a.variantSampled = sampled
+		a.mu.Unlock()
+		a.saveVariantState()
+		a.addLog(fmt.Sprintf("A/B sample complete, holding back the remaining recipients for %s while opens and clicks come in", a.variantWait()))
+	}
+	if time.Since(sampled) < a.variantWait() {
+		return
+	}
+
+	winner, rate, ok := variantWinner(stats, a.cfg.Mail.Variants, a.cfg.Tracking.Clicks, a.variantMinEvents())
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	a.variantWinner = winner
+	a.mu.Unlock()
+	a.saveVariantState()
+	metric := "opened"
+	if a.cfg.Tracking.Clicks {
+		metric = "clicked"
+	}
+	a.addLog(fmt.Sprintf("A/B winner picked: %s (%.1f%% %s), sending it to everyone else", winner, rate*100, metric))
+}
+
+// abTesting: Whether a winner is to be picked among the variants
+func (a *App) abTesting() bool {
+	return a.cfg.Mail.VariantSampleSize > 0 && len(a.cfg.Mail.Variants) >= 2
+}
+
+// variantHolding: Whether the sample is complete and the winner not picked
+// yet, so the remaining recipients wait
+func (a *App) variantHolding() bool {
+	if !a.abTesting() {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.variantSampled.IsZero() && a.variantWinner == ""
+}
+
+func (a *App) variantStatePath() string {
+	if a.cfg.Mail.VariantStatePath != "" {
+		return a.cfg.Mail.VariantStatePath
+	}
+	return a.cfg.resolve(defaultVariantStatePath)
+}
+
+// variantNames: The configured variant names, comma separated. They tell
+// which A/B test a saved decision belongs to.
+func (a *App) variantNames() string {
+	names := make([]string, len(a.cfg.Mail.Variants))
+	for i, v := range a.cfg.Mail.Variants {
+		names[i] = v.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// loadVariantState: Restores when the sample was complete and which winner
+// was picked, so a restart neither waits again nor forgets the winner. A
+// decision saved for other variants is ignored. A missing file is no
+// decision yet.
+func (a *App) loadVariantState() error {
+	if !a.abTesting() {
+		return nil
+	}
+	data, err := os.ReadFile(a.variantStatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(data)), ";")
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) != a.variantNames() {
+		return nil
+	}
+	sampled, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[0]))
+	if err != nil {
+		return fmt.Errorf("invalid A/B state in %s: %v", a.variantStatePath(), err)
+	}
+	winner := ""
+	for _, part := range parts[2:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if key != "winner" {
+			continue
+		}
+		for _, v := range a.cfg.Mail.Variants {
+			if v.Name == value {
+				winner = value
+			}
+		}
+	}
+
+	a.mu.Lock()
+	a.variantSampled = sampled
+	a.variantWinner = winner
+	a.mu.Unlock()
+	return nil
+}
+
+// saveVariantState: Writes the A/B decision in the database layout:
+// {ISO8601_SAMPLED} ; {VARIANTS} ; winner={NAME}
+func (a *App) saveVariantState() {
+	a.mu.Lock()
+	line := a.variantSampled.UTC().Format(time.RFC3339) + " ; " + a.variantNames()
+	if a.variantWinner != "" {
+		line += " ; winner=" + a.variantWinner
+	}
+	a.mu.Unlock()
+
+	if err := os.WriteFile(a.variantStatePath(), []byte(line+"\n"), 0644); err != nil {
+		a.addLog(fmt.Sprintf("Error saving A/B state: %v", err))
+	}
+}
+
+func (a *App) variantWait() time.Duration {
+	if a.cfg.Mail.VariantWaitMinutes > 0 {
+		return time.Duration(a.cfg.Mail.VariantWaitMinutes) * time.Minute
+	}
+	return defaultVariantWait
+}
+
+func (a *App) variantMinEvents() int {
+	if a.cfg.Mail.VariantMinEvents > 0 {
+		return a.cfg.Mail.VariantMinEvents
+	}
+	return defaultVariantMinEvents
+}
+
+// variantWinner: The variant with the best click (or open) rate. There is
+// none while the variants have fewer than minEvents clicks (or opens) in
+// all, or when the best rate is shared.
+func variantWinner(stats Stats, variants []MailVariant, clicks bool, minEvents int) (string, float64, bool) {
+	winner, best, tie, events := "", -1.0, false, 0
+	for _, v := range variants {
+		vs := stats.Variants[v.Name]
+		if vs == nil || vs.Sent == 0 {
+			return "", 0, false
+		}
+		hits := vs.Opened
+		if clicks {
+			hits = vs.Clicked
+		}
+		events += hits
+		rate := float64(hits) / float64(vs.Sent)
+		switch {
+		case rate > best:
+			winner, best, tie = v.Name, rate, false
+		case rate == best:
+			tie = true
+		}
+	}
+	if tie || events == 0 || events < minEvents {
+		return "", 0, false
+	}
+	return winner, best, true
+}
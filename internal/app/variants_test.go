@@ -0,0 +1,204 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPickVariant(t *testing.T) {
+	variants := []MailVariant{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}}
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		email := fmt.Sprintf("user%d@example.com", i)
+		v := pickVariant(email, variants)
+		if again := pickVariant(" USER"+email[4:]+" ", variants); again != v {
+			t.Fatalf("%s got %s, then %s", email, v, again)
+		}
+		counts[v]++
+	}
+	if counts["a"] < 2800 || counts["a"] > 3200 {
+		t.Errorf("weights 3:1 gave %v", counts)
+	}
+}
+
+func TestVariantWinner(t *testing.T) {
+	variants := []MailVariant{{Name: "a"}, {Name: "b"}}
+	stats := func(a, b VariantStats) Stats {
+		return Stats{Variants: map[string]*VariantStats{"a": &a, "b": &b}}
+	}
+	tests := []struct {
+		name   string
+		stats  Stats
+		clicks bool
+		want   string
+	}{
+		{"clicks", stats(VariantStats{Sent: 100, Clicked: 10}, VariantStats{Sent: 100, Clicked: 20}), true, "b"},
+		{"opens", stats(VariantStats{Sent: 100, Opened: 30, Clicked: 1}, VariantStats{Sent: 100, Opened: 10, Clicked: 30}), false, "a"},
+		{"rate, not count", stats(VariantStats{Sent: 200, Clicked: 20}, VariantStats{Sent: 100, Clicked: 15}), true, "b"},
+		{"tie", stats(VariantStats{Sent: 100, Clicked: 15}, VariantStats{Sent: 100, Clicked: 15}), true, ""},
+		{"nothing tracked yet", stats(VariantStats{Sent: 100}, VariantStats{Sent: 100}), true, ""},
+		{"too few events", stats(VariantStats{Sent: 100, Clicked: 1}, VariantStats{Sent: 100, Clicked: 3}), true, ""},
+		{"variant not sent", Stats{Variants: map[string]*VariantStats{"a": {Sent: 100, Clicked: 50}}}, true, ""},
+	}
+	for _, tt := range tests {
+		winner, _, ok := variantWinner(tt.stats, variants, tt.clicks, 10)
+		if winner != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: winner %q (%v), want %q", tt.name, winner, ok, tt.want)
+		}
+	}
+}
+
+func TestCheckVariantWinnerWaits(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Mail.Variants = []MailVariant{{Name: "a"}, {Name: "b"}}
+	a.cfg.Mail.VariantSampleSize = 100
+	a.cfg.Mail.VariantWaitMinutes = 30
+	a.cfg.Tracking.Clicks = true
+	stats := Stats{Variants: map[string]*VariantStats{
+		"a": {Sent: 100, Clicked: 10},
+		"b": {Sent: 100, Clicked: 20},
+	}}
+
+	a.checkVariantWinner(stats)
+	if a.variantWinner != "" || a.variantSampled.IsZero() {
+		t.Fatalf("picked %q as soon as the sample was sent", a.variantWinner)
+	}
+	a.variantSampled = time.Now().Add(-31 * time.Minute)
+	a.checkVariantWinner(stats)
+	if a.variantWinner != "b" {
+		t.Errorf("winner %q after the wait, want b", a.variantWinner)
+	}
+}
+
+func TestLoadTemplatesNeedsTrackingForSample(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Mail.Template = writeTestFile(t, a, "mail.html", "<p>Hi</p>")
+	a.cfg.Mail.Variants = []MailVariant{{Name: "a"}, {Name: "b"}}
+	a.cfg.Mail.VariantSampleSize = 100
+	if err := a.loadTemplates(); err == nil {
+		t.Error("sample size accepted without tracking")
+	}
+	a.cfg.Tracking.Opens = true
+	if err := a.loadTemplates(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMessageForKeepsVariant(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Mail.Template = writeTestFile(t, a, "mail.html", "base")
+	writeTestFile(t, a, "mail.tr.html", "base tr")
+	writeTestFile(t, a, "mail-b.html", "b")
+	writeTestFile(t, a, "mail-c.html", "c")
+	writeTestFile(t, a, "mail-c.tr.html", "c tr")
+	a.cfg.Mail.Subject = "Hello"
+	a.cfg.Mail.Subjects = map[string]string{"tr": "Merhaba"}
+	a.cfg.Mail.Variants = []MailVariant{
+		{Name: "a"},
+		{Name: "b", Subject: "Hello from b", Template: filepath.Join(a.cfg.dir, "mail-b.html")},
+		{Name: "c", Subjects: map[string]string{"tr": "Merhaba c"}, Template: filepath.Join(a.cfg.dir, "mail-c.html")},
+	}
+	if err := a.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		variant, lang, subject, body string
+	}{
+		{"", "tr", "Merhaba", "base tr"},
+		{"a", "tr", "Merhaba", "base tr"},
+		// No translation of b: its own subject and template, untranslated
+		{"b", "tr", "Hello from b", "b"},
+		{"b", "", "Hello from b", "b"},
+		{"c", "tr", "Merhaba c", "c tr"},
+		{"c", "", "Hello", "c"},
+	}
+	for _, tt := range tests {
+		r := &Recipient{Email: "x@example.com", Variant: tt.variant, Fields: map[string]string{FieldLang: tt.lang}}
+		subject, body := a.messageFor(r)
+		if subject != tt.subject || body != tt.body {
+			t.Errorf("variant %q, lang %q: %q, %q; want %q, %q", tt.variant, tt.lang, subject, body, tt.subject, tt.body)
+		}
+	}
+}
+
+func TestVariantStatsCountDelivered(t *testing.T) {
+	a := newTestApp(t,
+		"2026-01-01T00:00:00Z ; DONE ; a1@example.com ; ; variant=a ; clicks=x:1",
+		"2026-01-01T00:00:00Z ; BOUNCED ; a2@example.com ; 550 ; variant=a ; clicks=x:1",
+		"2026-01-01T00:00:00Z ; UNSUBSCRIBED ; a3@example.com ; ; variant=a ; sent_at=2026-01-01T00:00:00Z ; clicks=x:1",
+		"2026-01-01T00:00:00Z ; UNSUBSCRIBED ; a4@example.com ; ; variant=a",
+		"2026-01-01T00:00:00Z ; FAILED ; a5@example.com ; timeout ; variant=a",
+	)
+	stats, err := GetStats(a.cfg.Database.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := stats.Variants["a"]
+	if vs.Sent != 3 || vs.Failed != 1 || vs.Clicked != 3 {
+		t.Errorf("variant a: %+v, want 3 sent, 1 failed, 3 clicked", *vs)
+	}
+}
+
+func TestVariantStateSurvivesRestart(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Mail.Variants = []MailVariant{{Name: "a"}, {Name: "b"}}
+	a.cfg.Mail.VariantSampleSize = 100
+	a.cfg.Tracking.Clicks = true
+	stats := Stats{Variants: map[string]*VariantStats{
+		"a": {Sent: 100, Clicked: 10},
+		"b": {Sent: 100, Clicked: 20},
+	}}
+	a.checkVariantWinner(stats)
+
+	restarted := &App{cfg: a.cfg}
+	if err := restarted.loadVariantState(); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.variantSampled.Equal(a.variantSampled.Truncate(time.Second)) {
+		t.Errorf("sampled at %v after a restart, want %v", restarted.variantSampled, a.variantSampled)
+	}
+
+	restarted.variantSampled = time.Now().Add(-2 * time.Hour)
+	restarted.checkVariantWinner(stats)
+	again := &App{cfg: a.cfg}
+	if err := again.loadVariantState(); err != nil {
+		t.Fatal(err)
+	}
+	if again.variantWinner != "b" {
+		t.Errorf("winner %q after a restart, want b", again.variantWinner)
+	}
+
+	// A decision for other variants belongs to another test
+	other := *a.cfg
+	other.Mail.Variants = []MailVariant{{Name: "a"}, {Name: "c"}}
+	changed := &App{cfg: &other}
+	if err := changed.loadVariantState(); err != nil {
+		t.Fatal(err)
+	}
+	if changed.variantWinner != "" || !changed.variantSampled.IsZero() {
+		t.Errorf("kept the decision of other variants: %q, %v", changed.variantWinner, changed.variantSampled)
+	}
+}
+
+func TestClaimHeldDuringVariantWait(t *testing.T) {
+	a := newTestApp(t, "2026-01-01T00:00:00Z ; PENDING ; rest@example.com")
+	a.cfg.Mail.Variants = []MailVariant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}
+	a.cfg.Mail.VariantSampleSize = 100
+	a.variantSampled = time.Now()
+
+	if _, err := a.claimRecipient(); !errors.Is(err, errVariantHold) {
+		t.Fatalf("claim during the wait: %v, want errVariantHold", err)
+	}
+	a.variantWinner = "b"
+	r, err := a.claimRecipient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Variant != "b" {
+		t.Errorf("variant %q after the winner was picked, want b", r.Variant)
+	}
+}
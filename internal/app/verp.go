@@ -0,0 +1,82 @@
+// verp.go: Variable envelope return paths for reliable bounce attribution
+
+package app
+
+import (
+	"errors"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// validateReturnPath: A pattern needs exactly one {token} in its local part
+func validateReturnPath(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if strings.Count(pattern, "{token}") != 1 {
+		return errors.New("smtp.return_path must contain the {token} placeholder once")
+	}
+	local, domain, ok := strings.Cut(pattern, "@")
+	if !ok || domain == "" || !strings.Contains(local, "{token}") {
+		return errors.New("smtp.return_path must look like bounces+{token}@example.com")
+	}
+	return nil
+}
+
+// EncodeVERP: Builds the envelope sender for a recipient. The token is the
+// classic VERP form of the address, user@example.com -> user=example.com.
+func EncodeVERP(pattern, email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return ""
+	}
+	return strings.Replace(pattern, "{token}", local+"="+strings.ToLower(domain), 1)
+}
+
+// DecodeVERP: Recovers the recipient from an address built by EncodeVERP.
+// Matching is case-insensitive since some MTAs fold local parts.
+func DecodeVERP(pattern, addr string) (string, bool) {
+	prefix, suffix, ok := strings.Cut(pattern, "{token}")
+	if !ok {
+		return "", false
+	}
+	addr = strings.Trim(strings.TrimSpace(addr), "<>")
+	if len(addr) <= len(prefix)+len(suffix) ||
+		!strings.EqualFold(addr[:len(prefix)], prefix) ||
+		!strings.EqualFold(addr[len(addr)-len(suffix):], suffix) {
+		return "", false
+	}
+
+	token := addr[len(prefix) : len(addr)-len(suffix)]
+	i := strings.LastIndex(token, "=")
+	if i <= 0 || i == len(token)-1 {
+		return "", false
+	}
+	return token[:i] + "@" + token[i+1:], true
+}
+
+// verpRecipient: Finds the VERP address a bounce was delivered to and
+// decodes the original recipient from it
+func verpRecipient(pattern string, h mail.Header) string {
+	if pattern == "" {
+		return ""
+	}
+	for _, key := range []string{"Delivered-To", "X-Original-To", "To", "Envelope-To", "Return-Path"} {
+		for _, value := range h[textproto.CanonicalMIMEHeaderKey(key)] {
+			candidates := []string{value}
+			if list, err := mail.ParseAddressList(value); err == nil {
+				candidates = candidates[:0]
+				for _, a := range list {
+					candidates = append(candidates, a.Address)
+				}
+			}
+			for _, c := range candidates {
+				if email, ok := DecodeVERP(pattern, c); ok {
+					return email
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"net/mail"
+	"testing"
+)
+
+func TestVERPRoundTrip(t *testing.T) {
+	const pattern = "bounces+{token}@sender.example"
+	tests := []struct {
+		email, encoded, decoded string
+	}{
+		{"user@Example.COM", "bounces+user=example.com@sender.example", "user@example.com"},
+		{"first.last+tag@example.com", "bounces+first.last+tag=example.com@sender.example", "first.last+tag@example.com"},
+		{"a=b@example.com", "bounces+a=b=example.com@sender.example", "a=b@example.com"},
+	}
+	for _, tt := range tests {
+		encoded := EncodeVERP(pattern, tt.email)
+		if encoded != tt.encoded {
+			t.Errorf("EncodeVERP(%q) = %q, want %q", tt.email, encoded, tt.encoded)
+		}
+		// MTAs may fold the case and add angle brackets
+		decoded, ok := DecodeVERP(pattern, " <"+encoded+"> ")
+		if !ok || decoded != tt.decoded {
+			t.Errorf("DecodeVERP(%q) = %q, %v; want %q", encoded, decoded, ok, tt.decoded)
+		}
+	}
+}
+
+func TestDecodeVERPRejects(t *testing.T) {
+	const pattern = "bounces+{token}@sender.example"
+	for _, addr := range []string{
+		"bounces@sender.example",
+		"bounces+@sender.example",
+		"bounces+user@sender.example",
+		"bounces+user=@sender.example",
+		"bounces+=example.com@sender.example",
+		"other+user=example.com@sender.example",
+		"bounces+user=example.com@other.example",
+	} {
+		if email, ok := DecodeVERP(pattern, addr); ok {
+			t.Errorf("DecodeVERP(%q) = %q", addr, email)
+		}
+	}
+	if _, ok := DecodeVERP("", "bounces+user=example.com@sender.example"); ok {
+		t.Error("decoded without a pattern")
+	}
+}
+
+func TestValidateReturnPath(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"":                             true,
+		"bounces+{token}@example.com":  true,
+		"{token}@bounces.example.com":  true,
+		"bounces@example.com":          false,
+		"bounces+{token}{token}@x.com": false,
+		"bounces@{token}.example.com":  false,
+		"bounces+{token}":              false,
+	} {
+		if err := validateReturnPath(pattern); (err == nil) != valid {
+			t.Errorf("validateReturnPath(%q) = %v", pattern, err)
+		}
+	}
+}
+
+func TestVERPRecipient(t *testing.T) {
+	const pattern = "bounces+{token}@sender.example"
+	header := mail.Header{
+		"To":           {"Sender <bounces@sender.example>"},
+		"Delivered-To": {"postmaster@sender.example", "bounces+ann=example.com@sender.example"},
+	}
+	if got := verpRecipient(pattern, header); got != "ann@example.com" {
+		t.Errorf("verpRecipient = %q, want ann@example.com", got)
+	}
+	if got := verpRecipient("", header); got != "" {
+		t.Errorf("verpRecipient without a pattern = %q", got)
+	}
+}
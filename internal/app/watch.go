@@ -0,0 +1,142 @@
+// watch.go: Drop folder whose new files are imported automatically
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	processedDir = "processed"
+	rejectedDir  = "rejected"
+
+	// dropSettleDelay is how long a dropped file must go without writes
+	// before it is imported, so half-written files are not read
+	dropSettleDelay = 2 * time.Second
+)
+
+// startWatchDir: Creates the processed/ and rejected/ folders of the
+// configured drop folder, adds it to the watcher and imports the files
+// dropped while the app was not running
+func (a *App) startWatchDir() error {
+	dir := a.cfg.Import.WatchDir
+	if dir == "" {
+		return nil
+	}
+	for _, sub := range []string{processedDir, rejectedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	if err := a.Watcher.Add(dir); err != nil {
+		return err
+	}
+	a.dropTimers = make(map[string]*time.Timer)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			a.scheduleDrop(filepath.Join(dir, entry.Name()))
+		}
+	}
+	a.addLog(fmt.Sprintf("Watching %s for recipient files", dir))
+	return nil
+}
+
+// isDropEvent: The event is about a file directly inside the drop folder
+func (a *App) isDropEvent(event fsnotify.Event) bool {
+	dir := a.cfg.Import.WatchDir
+	if dir == "" {
+		return false
+	}
+	return filepath.Clean(filepath.Dir(event.Name)) == filepath.Clean(dir) &&
+		filepath.Clean(event.Name) != filepath.Clean(a.cfg.Database.Path)
+}
+
+// handleDropEvent: Schedules the import of a created or written file
+func (a *App) handleDropEvent(event fsnotify.Event) {
+	if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+		a.scheduleDrop(event.Name)
+	}
+}
+
+// scheduleDrop: (Re)starts the settle timer of a dropped file. Hidden and
+// temporary files are left alone, so writers can upload under a temporary
+// name and rename when done.
+func (a *App) scheduleDrop(path string) {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tmp", ".part", ".partial", ".crdownload":
+		return
+	}
+
+	a.dropMu.Lock()
+	defer a.dropMu.Unlock()
+	if t, ok := a.dropTimers[path]; ok {
+		t.Reset(dropSettleDelay)
+		return
+	}
+	a.dropTimers[path] = time.AfterFunc(dropSettleDelay, func() {
+		a.dropMu.Lock()
+		delete(a.dropTimers, path)
+		a.dropMu.Unlock()
+		a.importDropFile(path)
+	})
+}
+
+// importDropFile: Imports a dropped file through the same pipeline as the
+// Import screen, without a preview, and moves it to processed/ or rejected/
+func (a *App) importDropFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil && a.ownFiles()[abs] {
+		return
+	}
+
+	p := &ImportProgress{Source: path}
+	plan, err := a.planFile(path, a.cfg.Import.SQLiteQuery, p)
+	if err == nil && plan.Found == 0 {
+		err = errors.New("no addresses found")
+	}
+	if err == nil {
+		_, err = a.CommitImport(plan, p)
+	}
+
+	if err != nil {
+		a.addLog(fmt.Sprintf("Watch folder: rejected %s: %v", filepath.Base(path), err))
+		a.moveDropFile(path, rejectedDir)
+		return
+	}
+	a.moveDropFile(path, processedDir)
+}
+
+// moveDropFile: Moves a handled file into a subfolder of the drop folder,
+// adding a timestamp if a file of that name was handled before
+func (a *App) moveDropFile(path, sub string) {
+	name := filepath.Base(path)
+	target := filepath.Join(filepath.Dir(path), sub, name)
+	if _, err := os.Stat(target); err == nil {
+		ext := filepath.Ext(name)
+		target = filepath.Join(filepath.Dir(path), sub, strings.TrimSuffix(name, ext)+"-"+time.Now().Format("20060102-150405")+ext)
+	}
+	if err := os.Rename(path, target); err != nil {
+		a.addLog(fmt.Sprintf("Watch folder: error moving %s: %v", name, err))
+		return
+	}
+	a.addLog(fmt.Sprintf("Watch folder: moved %s to %s/", name, sub))
+}
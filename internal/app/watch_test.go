@@ -0,0 +1,143 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// newDropTestApp: Test app with a drop folder and its subfolders
+func newDropTestApp(t *testing.T) *App {
+	t.Helper()
+	a := newTestApp(t)
+	a.cfg.Import.WatchDir = filepath.Join(a.cfg.dir, "drop")
+	for _, sub := range []string{processedDir, rejectedDir} {
+		if err := os.MkdirAll(filepath.Join(a.cfg.Import.WatchDir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.dropTimers = make(map[string]*time.Timer)
+	return a
+}
+
+func TestImportDropFile(t *testing.T) {
+	a := newDropTestApp(t)
+	good := filepath.Join(a.cfg.Import.WatchDir, "list.txt")
+	bad := filepath.Join(a.cfg.Import.WatchDir, "empty.txt")
+	if err := os.WriteFile(good, []byte("ann@example.com\nbob@example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("no addresses here\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a.importDropFile(good)
+	a.importDropFile(bad)
+
+	if records := readTestRecords(t, a); len(records) != 2 {
+		t.Errorf("%d records after the drop, want 2", len(records))
+	}
+	for path, sub := range map[string]string{good: processedDir, bad: rejectedDir} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left in the drop folder", filepath.Base(path))
+		}
+		if _, err := os.Stat(filepath.Join(a.cfg.Import.WatchDir, sub, filepath.Base(path))); err != nil {
+			t.Errorf("%s not moved to %s/: %v", filepath.Base(path), sub, err)
+		}
+	}
+}
+
+func TestMoveDropFileKeepsEarlierFiles(t *testing.T) {
+	a := newDropTestApp(t)
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(a.cfg.Import.WatchDir, "list.txt")
+		if err := os.WriteFile(path, []byte("ann@example.com\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		a.moveDropFile(path, processedDir)
+	}
+	entries, err := os.ReadDir(filepath.Join(a.cfg.Import.WatchDir, processedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files in processed/, want both", len(entries))
+	}
+}
+
+func TestScheduleDropSkipsTemporaryFiles(t *testing.T) {
+	a := newDropTestApp(t)
+	for _, name := range []string{".list.csv", "list.csv~", "list.csv.part", "list.tmp", "list.crdownload"} {
+		a.scheduleDrop(filepath.Join(a.cfg.Import.WatchDir, name))
+	}
+	if len(a.dropTimers) != 0 {
+		t.Errorf("scheduled %d temporary files", len(a.dropTimers))
+	}
+
+	path := filepath.Join(a.cfg.Import.WatchDir, "list.csv")
+	a.scheduleDrop(path)
+	a.scheduleDrop(path)
+	a.dropMu.Lock()
+	defer a.dropMu.Unlock()
+	if len(a.dropTimers) != 1 {
+		t.Fatalf("%d timers for one file written twice, want 1", len(a.dropTimers))
+	}
+	a.dropTimers[path].Stop()
+}
+
+func TestIsDropEvent(t *testing.T) {
+	a := newDropTestApp(t)
+	dir := a.cfg.Import.WatchDir
+	a.cfg.Database.Path = filepath.Join(dir, "data.txt")
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{filepath.Join(dir, "list.csv"), true},
+		{filepath.Join(dir, processedDir, "list.csv"), false},
+		{filepath.Join(a.cfg.dir, "list.csv"), false},
+		{a.cfg.Database.Path, false},
+	}
+	for _, tt := range tests {
+		if got := a.isDropEvent(fsnotify.Event{Name: tt.name, Op: fsnotify.Create}); got != tt.want {
+			t.Errorf("isDropEvent(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportsWaitForEachOther(t *testing.T) {
+	a := newTestApp(t)
+	unlock, err := a.lockImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another App on the same database stands in for another process
+	other := &App{cfg: a.cfg}
+	locked := make(chan struct{})
+	go func() {
+		release, err := other.lockImport()
+		if err != nil {
+			t.Error(err)
+			close(locked)
+			return
+		}
+		close(locked)
+		release()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("second import ran while the first held the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second import still waiting after the first released the lock")
+	}
+}
@@ -2,46 +2,84 @@ package main
 
 import (
 	"bulk-mail/internal/app"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 func main() {
-	// Check if required files exist
-	if !filesExist() {
-		fmt.Println("Required files not found (config.yaml, data.txt, mail.html)")
+	configPath := flag.String("config", "", "config file")
+	workdir := flag.String("workdir", "", "directory to run in")
+	dbPath := flag.String("db", "", "database file")
+	flag.Usage = func() { app.PrintUsage(os.Stderr) }
+	flag.Parse()
+
+	if *workdir != "" {
+		if err := os.Chdir(*workdir); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	application := &app.App{ConfigPath: *configPath, DBPath: *dbPath}
+
+	// Subcommands run without the TUI
+	if flag.NArg() > 0 {
+		os.Exit(app.RunCLI(application, flag.Args(), os.Stdout, os.Stderr))
+	}
+
+	// Check if required files exist: the config, and the database and
+	// template it names
+	files, err := application.RequiredFiles()
+	if err != nil {
+		fmt.Printf("Error loading %s: %v\n", application.ConfigFile(), err)
+		os.Exit(1)
+	}
+	var missing []string
+	for _, file := range files {
+		if file != "" && !fileExists(file) {
+			missing = append(missing, file)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Required files not found (%s)\n", strings.Join(missing, ", "))
 		fmt.Print("Do you want to create sample files? (y/n): ")
 
 		var response string
 		fmt.Scanln(&response)
 
 		if strings.ToLower(response) == "y" || strings.ToLower(response) == "yes" {
-			if err := app.CreateSampleConfig(); err != nil {
-				fmt.Printf("Error creating config: %v\n", err)
-				os.Exit(1)
-			}
-			if err := app.CreateSampleTemplate("mail.html"); err != nil {
-				fmt.Printf("Error creating template: %v\n", err)
-				os.Exit(1)
+			samples := []struct {
+				path   string
+				what   string
+				create func(string) error
+			}{
+				{files[0], "config", app.CreateSampleConfig},
+				{files[2], "template", app.CreateSampleTemplate},
+				{files[1], "data", app.CreateSampleData},
 			}
-			if err := app.CreateSampleData("data.txt"); err != nil {
-				fmt.Printf("Error creating data: %v\n", err)
-				os.Exit(1)
+			for _, sample := range samples {
+				if sample.path == "" || fileExists(sample.path) {
+					continue
+				}
+				if err := createSample(sample.path, sample.create); err != nil {
+					fmt.Printf("Error creating %s: %v\n", sample.what, err)
+					os.Exit(1)
+				}
 			}
 
 			fmt.Println("\nSample files created successfully!")
-			fmt.Println("Please edit config.yaml with your SMTP settings and restart the application.")
+			fmt.Printf("Please edit %s with your SMTP settings and restart the application.\n", files[0])
 		}
 		return
 	}
 
-	application := &app.App{}
 	if err := application.Init(); err != nil {
 		fmt.Printf("Init error: %v\n", err)
 		os.Exit(1)
 	}
-	defer application.Watcher.Close()
+	defer application.Close()
 
 	if err := app.RunTUI(application); err != nil {
 		fmt.Printf("Error: %v", err)
@@ -49,12 +87,15 @@ func main() {
 	}
 }
 
-func filesExist() bool {
-	files := []string{"config.yaml", "data.txt", "mail.html"}
-	for _, file := range files {
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			return false
-		}
+// createSample writes a sample file, creating its directory first
+func createSample(path string, create func(string) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
 	}
-	return true
+	return create(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
 }